@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 	"webApp/pkg/data"
+	"webApp/pkg/export"
+	"webApp/pkg/repository"
+	"webApp/pkg/validator"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Credentials struct {
@@ -23,31 +28,60 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
 	// look up the user by email address
-	user, err := app.DB.GetUserByEmail(creds.Username)
+	user, err := app.DB.GetUserByEmail(r.Context(), creds.Username)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Details: map[string]any{"email": creds.Username}})
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
 	// check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
+	valid, err := user.PasswordMatches(creds.Password)
+	if err != nil || !valid {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, TargetUserID: user.ID, Details: map[string]any{"email": creds.Username}})
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// upgrade hashes made with outdated parameters while we have the plain text
+	app.rehashPassword(r.Context(), user, creds.Password)
+
+	// users with two-factor enabled get a challenge token instead of tokens
+	enabled, err := app.mfaEnabled(r.Context(), user.ID)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if enabled {
+		mfaToken, err := app.generateMFAToken(user)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		_ = app.writeJSON(w, http.StatusAccepted, MFAChallenge{Required: true, Token: mfaToken})
 		return
 	}
 
 	// generate tokens
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID})
+
 	http.SetCookie(w, &http.Cookie{
 		Name: "Host-refresh_token",
 		Path: "/",
@@ -64,10 +98,21 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
+// refreshDue reports whether a refresh token may be swapped for new tokens yet:
+// when the access token issued with it has expired, or will within 30 seconds,
+// or the refresh token itself will. Refresh tokens issued before they carried
+// access_exp only go by the latter.
+func refreshDue(claims *Claims) bool {
+	if claims.AccessExpiresAt != nil && time.Until(claims.AccessExpiresAt.Time) <= 30*time.Second {
+		return true
+	}
+	return time.Until(claims.ExpiresAt.Time) <= 30*time.Second
+}
+
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -79,31 +124,40 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30 * time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+	if claims.Purpose != "" {
+		app.errorJSON(w, r, errors.New("invalid refresh token"), http.StatusBadRequest)
+		return
+	}
+
+	if !refreshDue(claims) {
+		app.errorJSON(w, r, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
 		return
 	}
 
 	// get the user id from the claims
 	userID, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errors.New("unknown user"), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -133,31 +187,40 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 			})
 
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			if claims.Purpose != "" {
+				app.errorJSON(w, r, errors.New("invalid refresh token"), http.StatusBadRequest)
 				return
 			}
 
 			// if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30 * time.Second {
-			// 	app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+			// 	app.errorJSON(w, r, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
 			// 	return
 			// }
 
 			// get the user id from the claims
 			userID, err := strconv.Atoi(claims.Subject)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
 				return
 			}
 
-			user, err := app.DB.GetUser(userID)
+			user, err := app.DB.GetUser(r.Context(), userID)
+			if errors.Is(err, repository.ErrNotFound) {
+				app.errorJSON(w, r, errors.New("unknown user"), http.StatusBadRequest)
+				return
+			}
 			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+				app.dbErrorJSON(w, r, err)
 				return
 			}
 
 			tokenPairs, err := app.generateTokenPair(user)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
 				return
 			}
 
@@ -179,49 +242,292 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 }
 
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	w.Header().Add("Vary", "Accept")
+
+	switch format := negotiate(r, "application/json", export.CSV, export.NDJSON); format {
+	case "":
+		app.errorJSON(w, r, errors.New("users are listed as application/json, "+export.CSV+" or "+export.NDJSON), http.StatusNotAcceptable)
+		return
+	case export.CSV, export.NDJSON:
+		app.exportUsers(w, r, format)
+		return
+	}
+
+	users, err := app.DB.AllUsers(r.Context())
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	// no users is an empty list, not null
+	if users == nil {
+		users = []*data.User{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, users)
+}
+
+const (
+	// how many users a search returns
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// searchUsers finds users by the words of their names and email, best match
+// first, for autocomplete: ?q=jo sm finds John Smith. limit caps the number of
+// users returned.
+func (app *application) searchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if err := repository.CheckSearch(query); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			app.errorJSON(w, r, errors.New("limit must be between 1 and "+strconv.Itoa(maxSearchLimit)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := app.DB.SearchUsers(r.Context(), query, limit)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
+	if users == nil {
+		users = []*data.User{}
+	}
+
 	_ = app.writeJSON(w, http.StatusOK, users)
 }
 
+// exportUsers sends every user as a download in format, streamed from the
+// database. Once the first bytes are sent the status can not change any more,
+// so an error after that cuts the download short, and is only logged.
+func (app *application) exportUsers(w http.ResponseWriter, r *http.Request, format string) {
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+export.FileName(format)+`"`)
+
+	sw := &startedWriter{ResponseWriter: w}
+	err := export.Users(r.Context(), sw, format, app.DB)
+	if err == nil {
+		return
+	}
+
+	if !sw.started {
+		w.Header().Del("Content-Disposition")
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	log.Printf("request %s: export stopped: %s", middleware.GetReqID(r.Context()), err)
+}
+
+// startedWriter records whether anything was written to a response yet
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) Write(b []byte) (int, error) {
+	sw.started = true
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *startedWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
+	// sent back in If-Match to update the user
+	w.Header().Set("ETag", userETag(user.Version))
 	_ = app.writeJSON(w, http.StatusOK, user)
 }
 
+// updateUser replaces the user whose id is in the body; see replaceUser
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	version, ok := app.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
 	var user data.User
 	err := app.readJSON(w, r, &user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	user.Version = version
+
+	// the stored user, to record what the update changed
+	oldUser, err := app.DB.GetUser(r.Context(), user.ID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	app.saveUser(w, r, oldUser, user)
+}
+
+// replaceUser replaces every field a client can set of a user with the ones in
+// the body; fields left out are blanked. See patchUser to change only some.
+func (app *application) replaceUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	version, ok := app.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var user data.User
+	err = app.readJSON(w, r, &user)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if user.ID != 0 && user.ID != userID {
+		app.errorJSON(w, r, errors.New("id can not be changed"), http.StatusBadRequest)
+		return
+	}
+	user.ID = userID
+	user.Version = version
+
+	oldUser, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	app.saveUser(w, r, oldUser, user)
+}
+
+// patchUser changes the fields of a user that are in the body, an RFC 7386
+// merge patch, and leaves the others as they are.
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !isMergePatch(r) {
+		app.errorJSON(w, r, errors.New("content type must be "+mergePatchType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	version, ok := app.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	err = app.readJSON(w, r, &patch)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if patch == nil {
+		app.errorJSON(w, r, errors.New("merge patch must be a JSON object"), http.StatusBadRequest)
+		return
+	}
+
+	oldUser, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	// the patch is applied to what we read, so that is what must still be stored
+	if version != 0 && version != oldUser.Version {
+		app.dbErrorJSON(w, r, repository.ErrVersionMismatch)
+		return
+	}
+
+	user := *oldUser
+	err = applyUserPatch(&user, patch)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	app.saveUser(w, r, oldUser, user)
+}
+
+// ifMatchVersion returns the user version the request's If-Match header asks
+// for, 0 meaning any. Without one it sends 428, and 412 when it can not match.
+func (app *application) ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.errorJSON(w, r, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+		return 0, false
+	}
+
+	version, ok := versionFromIfMatch(ifMatch)
+	if !ok {
+		app.dbErrorJSON(w, r, repository.ErrVersionMismatch)
+		return 0, false
+	}
+
+	return version, true
+}
+
+// saveUser validates and stores user, the edited oldUser, records the change
+// in the audit log and answers the request with the user's new ETag.
+func (app *application) saveUser(w http.ResponseWriter, r *http.Request, oldUser *data.User, user data.User) {
+	v := validator.New()
+	validateUser(v, &user)
+	if !v.Valid() {
+		app.validationErrorJSON(w, r, v.Errors)
 		return
 	}
 
-	err = app.DB.UpdateUser(user)
+	// If-Match: * updates the version we read, not whatever is there by now
+	if user.Version == 0 {
+		user.Version = oldUser.Version
+	}
+
+	err := app.DB.UpdateUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
+	actorID, _ := userIDFromContext(r)
+	app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditUserUpdated, TargetUserID: user.ID, Details: map[string]any{"changed": changedFields(oldUser, &user)}})
+	if oldUser.IsAdmin != user.IsAdmin {
+		app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditAdminChanged, TargetUserID: user.ID, Details: map[string]any{"from": oldUser.IsAdmin, "to": user.IsAdmin}})
+	}
+
+	// the update bumped the version by one
+	w.Header().Set("ETag", userETag(user.Version+1))
+
 	// there is no response to send to clientSide
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -229,36 +535,169 @@ func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	err = app.DB.DeleteUser(userID)
+	err = app.DB.DeleteUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
+	actorID, _ := userIDFromContext(r)
+	app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditUserDeleted, TargetUserID: userID})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+// restoreUser undoes the deletion of a user that has not been purged yet; admin only
+func (app *application) restoreUser(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.RestoreUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	actorID, _ := userIDFromContext(r)
+	app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditUserRestored, TargetUserID: userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// NewPassword is the payload for resetting a user's password
+type NewPassword struct {
+	Password string `json:"password"`
+}
+
+func (p *NewPassword) Validate(v *validator.Validator) {
+	validatePassword(v, "password", p.Password)
+}
+
+// resetUserPassword sets a new password for a user, and revokes the user's
+// API keys, so that whoever knew the old credentials is locked out; admin only
+func (app *application) resetUserPassword(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload NewPassword
+	if !app.readValidJSON(w, r, &payload) {
+		return
+	}
+
+	// a new password with the old keys still working would be no reset at all
+	revoked := []int{}
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		if err := repo.ResetPassword(r.Context(), userID, payload.Password); err != nil {
+			return err
+		}
+
+		keys, err := repo.AllAPIKeys(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if key.Revoked() {
+				continue
+			}
+			if err := repo.RevokeAPIKey(r.Context(), userID, key.ID); err != nil {
+				return err
+			}
+			revoked = append(revoked, key.ID)
+		}
+
+		return nil
+	})
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	actorID, _ := userIDFromContext(r)
+	app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditPasswordReset, TargetUserID: userID, Details: map[string]any{"revoked_api_keys": revoked}})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// NewUser is the payload for creating a user
+type NewUser struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	IsAdmin   int    `json:"is_admin"`
+}
+
+func (u *NewUser) Validate(v *validator.Validator) {
+	validateUser(v, &data.User{FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, IsAdmin: u.IsAdmin})
+	validatePassword(v, "password", u.Password)
+}
+
+// user returns the user to insert
+func (u *NewUser) user() data.User {
+	return data.User{
+		FirstName: u.FirstName,
+		LastName: u.LastName,
+		Email: u.Email,
+		Password: u.Password,
+		IsAdmin: u.IsAdmin,
+	}
+}
+
+func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
+	var user NewUser
+	if !app.readValidJSON(w, r, &user) {
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	newID, err := app.DB.InsertUser(r.Context(), user.user())
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
+	actorID, _ := userIDFromContext(r)
+	app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditUserCreated, TargetUserID: newID, Details: map[string]any{"email": user.Email, "is_admin": user.IsAdmin}})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// changedFields lists the user fields an update changes
+func changedFields(old, new *data.User) []string {
+	changed := []string{}
+	if old.FirstName != new.FirstName {
+		changed = append(changed, "first_name")
+	}
+	if old.LastName != new.LastName {
+		changed = append(changed, "last_name")
+	}
+	if old.Email != new.Email {
+		changed = append(changed, "email")
+	}
+	if old.IsAdmin != new.IsAdmin {
+		changed = append(changed, "is_admin")
+	}
+	return changed
+}
+
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
 	delCookie := http.Cookie{
 		Name: "Host-refresh_token",
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +13,7 @@ import (
 	"testing"
 	"time"
 	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -26,6 +30,7 @@ func Test_app_authenticate(t *testing.T) {
 		{"empty email", `{"email":""}`, http.StatusUnauthorized},
 		{"empty password", `{"email":"admin@example.com"}`, http.StatusUnauthorized},
 		{"invalid user", `{"email":"admin@someotherdomain.com", "password":"secret"}`, http.StatusUnauthorized},
+		{"mfa user", `{"email":"mfa@example.com", "password":"secret"}`, http.StatusAccepted},
 	}
 
 	for _, e := range theTests {
@@ -99,6 +104,37 @@ func Test_app_refresh(t *testing.T) {
 	}
 }
 
+func Test_app_refreshAfterAccessTokenExpires(t *testing.T) {
+	var tests = []struct {
+		name               string
+		accessExpiry       time.Duration
+		expectedStatusCode int
+	}{
+		{"access token expired", -time.Minute, http.StatusOK},
+		{"access token about to expire", 20 * time.Second, http.StatusOK},
+		{"access token still good", time.Minute, http.StatusTooEarly},
+	}
+
+	oldAccessTime := jwtTokenExpiry
+	defer func() { jwtTokenExpiry = oldAccessTime }()
+
+	for _, e := range tests {
+		jwtTokenExpiry = e.accessExpiry
+		tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User"})
+
+		postedData := url.Values{"refresh_token": {tokens.RefreshToken}}
+		req, _ := http.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.refresh).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status of %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
 func Test_app_userHandler(t *testing.T) {
 	var tests = []struct {
 		name           string
@@ -110,9 +146,10 @@ func Test_app_userHandler(t *testing.T) {
 	}{
 		{"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
 		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
+		{"deleteUser not found", "DELETE", "", "100", app.deleteUser, http.StatusNotFound},
 		{"deleteUser bad URL param", "DELETE", "", "Y", app.deleteUser, http.StatusBadRequest},
 		{"getUser valid", "GET", "", "1", app.getUser, http.StatusOK},
-		{"getUser invalid", "GET", "", "100", app.getUser, http.StatusBadRequest},
+		{"getUser not found", "GET", "", "100", app.getUser, http.StatusNotFound},
 		{"getUser bad URL param", "GET", "", "Y", app.getUser, http.StatusBadRequest},
 		{
 			"updateUser valid",
@@ -123,12 +160,20 @@ func Test_app_userHandler(t *testing.T) {
 			http.StatusNoContent,
 		},
 		{
-			"updateUser invalid",
+			"updateUser not found",
 			"PATCH",
 			`{"id":100,"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`,
 			"",
 			app.updateUser,
-			http.StatusBadRequest,
+			http.StatusNotFound,
+		},
+		{
+			"updateUser duplicate email",
+			"PATCH",
+			`{"id":2,"first_name":"MFA","last_name":"User","email":"admin@example.com"}`,
+			"",
+			app.updateUser,
+			http.StatusConflict,
 		},
 		{
 			"updateUser invalid json",
@@ -141,11 +186,35 @@ func Test_app_userHandler(t *testing.T) {
 		{
 			"insertUser valid",
 			"PUT",
-			`{"first_name":"Jack","last_name":"Smith","email":"jack@example.com"}`,
+			`{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"secret"}`,
 			"",
 			app.insertUser,
 			http.StatusNoContent,
 		},
+		{
+			"insertUser duplicate email",
+			"PUT",
+			`{"first_name":"Admin","last_name":"User","email":"admin@example.com","password":"secret"}`,
+			"",
+			app.insertUser,
+			http.StatusConflict,
+		},
+		{
+			"insertUser no password",
+			"PUT",
+			`{"first_name":"Jack","last_name":"Smith","email":"jack@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insertUser invalid fields",
+			"PUT",
+			`{"first_name":"","last_name":"Smith","email":"jack","password":"secret","is_admin":2}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
 		{
 			"insertUser invalid",
 			"PUT",
@@ -164,7 +233,13 @@ func Test_app_userHandler(t *testing.T) {
 		},
 	}
 
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
 	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
 		var req *http.Request
 		if e.json == "" {
 			req, _ = http.NewRequest(e.method, "/", nil)
@@ -172,6 +247,11 @@ func Test_app_userHandler(t *testing.T) {
 			req, _ = http.NewRequest(e.method, "/", strings.NewReader(e.json))
 		}
 
+		// the fixtures are all at version 1
+		if e.method == "PATCH" {
+			req.Header.Set("If-Match", `"1"`)
+		}
+
 		if e.paramID != "" {
 			// *Contextの作成
 			chiCtx := chi.NewRouteContext()
@@ -275,4 +355,541 @@ func Test_app_deleteRefreshCookie(t *testing.T) {
 	if !foundCookie {
 		t.Error("Host-refresh_token cookie not found!")
 	}
-}
\ No newline at end of file
+}
+
+func Test_app_restoreUser(t *testing.T) {
+	var tests = []struct {
+		name           string
+		paramID        string
+		userID         int
+		expectedStatus int
+	}{
+		{"deleted user", "3", 1, http.StatusNoContent},
+		{"not deleted", "2", 1, http.StatusNotFound},
+		{"bad URL param", "Y", 1, http.StatusBadRequest},
+		{"not an admin", "3", 2, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/", nil)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+		ctx = context.WithValue(ctx, contextUserIDKey, e.userID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.restoreUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_updateUserIfMatch(t *testing.T) {
+	var tests = []struct {
+		name           string
+		ifMatch        string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{"current version", `"1"`, http.StatusNoContent, `"2"`},
+		{"any version", "*", http.StatusNoContent, `"2"`},
+		{"old version", `"0"`, http.StatusPreconditionFailed, ""},
+		{"newer version", `"2"`, http.StatusPreconditionFailed, ""},
+		{"weak tag", `W/"1"`, http.StatusPreconditionFailed, ""},
+		{"not a tag", "1", http.StatusPreconditionFailed, ""},
+		{"missing", "", http.StatusPreconditionRequired, ""},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`))
+		if e.ifMatch != "" {
+			req.Header.Set("If-Match", e.ifMatch)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.updateUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Header().Get("ETag") != e.expectedETag {
+			t.Errorf("%s: expected ETag %q, but got %q", e.name, e.expectedETag, rr.Header().Get("ETag"))
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 1)
+		if changed := user.FirstName == "Administrator"; changed != (e.expectedStatus == http.StatusNoContent) {
+			t.Errorf("%s: expected the user to be changed only on success, but first name is %s", e.name, user.FirstName)
+		}
+	}
+}
+
+func Test_app_patchUser(t *testing.T) {
+	var tests = []struct {
+		name              string
+		paramID           string
+		contentType       string
+		json              string
+		expectedStatus    int
+		expectedFirstName string
+		expectedLastName  string
+	}{
+		{"first name only", "1", "application/merge-patch+json", `{"first_name":"Administrator"}`, http.StatusNoContent, "Administrator", "User"},
+		{"several fields", "1", "application/merge-patch+json", `{"first_name":"Jane","last_name":"Doe","is_admin":0}`, http.StatusNoContent, "Jane", "Doe"},
+		{"plain json", "1", "application/json", `{"last_name":"Smith"}`, http.StatusNoContent, "Admin", "Smith"},
+		{"empty patch", "1", "application/merge-patch+json", `{}`, http.StatusNoContent, "Admin", "User"},
+		{"same id", "1", "application/merge-patch+json", `{"id":1,"first_name":"Administrator"}`, http.StatusNoContent, "Administrator", "User"},
+		{"other id", "1", "application/merge-patch+json", `{"id":2}`, http.StatusBadRequest, "Admin", "User"},
+		{"remove required field", "1", "application/merge-patch+json", `{"first_name":null}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"blank field", "1", "application/merge-patch+json", `{"last_name":" "}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"invalid email", "1", "application/merge-patch+json", `{"email":"not an address"}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"invalid is_admin", "1", "application/merge-patch+json", `{"is_admin":2}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"first name too long", "1", "application/merge-patch+json", `{"first_name":"` + strings.Repeat("a", 256) + `"}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"last name too long", "1", "application/merge-patch+json", `{"last_name":"` + strings.Repeat("a", 256) + `"}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"email too long", "1", "application/merge-patch+json", `{"email":"` + strings.Repeat("a", 244) + `@example.com"}`, http.StatusUnprocessableEntity, "Admin", "User"},
+		{"longest first name", "1", "application/merge-patch+json", `{"first_name":"` + strings.Repeat("é", 255) + `"}`, http.StatusNoContent, strings.Repeat("é", 255), "User"},
+		{"wrong type", "1", "application/merge-patch+json", `{"first_name":1}`, http.StatusBadRequest, "Admin", "User"},
+		{"unknown field", "1", "application/merge-patch+json", `{"password":"secret"}`, http.StatusBadRequest, "Admin", "User"},
+		{"not an object", "1", "application/merge-patch+json", `null`, http.StatusBadRequest, "Admin", "User"},
+		{"duplicate email", "1", "application/merge-patch+json", `{"email":"mfa@example.com"}`, http.StatusConflict, "Admin", "User"},
+		{"wrong content type", "1", "text/plain", `{"first_name":"Administrator"}`, http.StatusUnsupportedMediaType, "Admin", "User"},
+		{"not found", "100", "application/merge-patch+json", `{"first_name":"Administrator"}`, http.StatusNotFound, "Admin", "User"},
+		{"bad URL param", "Y", "application/merge-patch+json", `{"first_name":"Administrator"}`, http.StatusBadRequest, "Admin", "User"},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(e.json))
+		req.Header.Set("Content-Type", e.contentType)
+		req.Header.Set("If-Match", `"1"`)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.patchUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 1)
+		if user.FirstName != e.expectedFirstName || user.LastName != e.expectedLastName {
+			t.Errorf("%s: expected %s %s, but got %s %s", e.name, e.expectedFirstName, e.expectedLastName, user.FirstName, user.LastName)
+		}
+
+		// fields left out of the patch are kept
+		if user.Email != "admin@example.com" {
+			t.Errorf("%s: expected the email address to be kept, but got %s", e.name, user.Email)
+		}
+	}
+}
+
+func Test_app_validationErrors(t *testing.T) {
+	oldDB := app.DB
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = oldDB }()
+
+	req, _ := http.NewRequest("PUT", "/", strings.NewReader(`{"first_name":" ","last_name":"Smith","email":"jack","is_admin":2}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.insertUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, but got %d", rr.Code)
+	}
+
+	var body Problem
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Type != problemValidation || body.Status != http.StatusUnprocessableEntity {
+		t.Errorf("expected a validation problem, but got %+v", body)
+	}
+
+	// every invalid field at once, and nothing about the valid ones
+	expected := map[string]string{
+		"first_name": "must not be blank",
+		"email":      "must be an email address",
+		"password":   "must not be blank",
+		"is_admin":   "must be 0 or 1",
+	}
+	if len(body.Errors) != len(expected) {
+		t.Errorf("expected errors for %d fields, but got %v", len(expected), body.Errors)
+	}
+	for field, message := range expected {
+		if messages := body.Errors[field]; len(messages) == 0 || messages[0] != message {
+			t.Errorf("%s: expected %q, but got %v", field, message, messages)
+		}
+	}
+
+	if body.Detail != "invalid email, first_name, is_admin, password" {
+		t.Errorf("unexpected detail %q", body.Detail)
+	}
+}
+
+func Test_app_patchUserStaleVersion(t *testing.T) {
+	oldDB := app.DB
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = oldDB }()
+
+	req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"first_name":"Administrator"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"2"`)
+
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.patchUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412, but got %d", rr.Code)
+	}
+}
+
+func Test_app_replaceUser(t *testing.T) {
+	var tests = []struct {
+		name           string
+		paramID        string
+		json           string
+		expectedStatus int
+	}{
+		{"valid", "1", `{"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusNoContent},
+		{"same id", "1", `{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusNoContent},
+		{"other id", "1", `{"id":2,"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusBadRequest},
+		{"fields left out", "1", `{"first_name":"Administrator"}`, http.StatusUnprocessableEntity},
+		{"not found", "100", `{"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusNotFound},
+		{"bad URL param", "Y", `{"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusBadRequest},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req, _ := http.NewRequest("PUT", "/", strings.NewReader(e.json))
+		req.Header.Set("If-Match", `"1"`)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.replaceUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_concurrentUpdates(t *testing.T) {
+	oldDB := app.DB
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = oldDB }()
+
+	// two admins read the same user
+	req, _ := http.NewRequest("GET", "/", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "2")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.getUser).ServeHTTP(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("expected ETag \"1\", but got %q", etag)
+	}
+
+	// and both save their changes; only the first one gets through
+	var statuses []int
+	for _, firstName := range []string{"First", "Second"} {
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"id":2,"first_name":"`+firstName+`","last_name":"User","email":"mfa@example.com"}`))
+		req.Header.Set("If-Match", etag)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.updateUser).ServeHTTP(rr, req)
+		statuses = append(statuses, rr.Code)
+	}
+
+	if statuses[0] != http.StatusNoContent || statuses[1] != http.StatusPreconditionFailed {
+		t.Errorf("expected 204 then 412, but got %v", statuses)
+	}
+
+	user, _ := app.DB.GetUser(context.Background(), 2)
+	if user.FirstName != "First" || user.Version != 2 {
+		t.Errorf("expected the first update at version 2, but got %s at version %d", user.FirstName, user.Version)
+	}
+}
+
+func Test_app_resetUserPassword(t *testing.T) {
+	var tests = []struct {
+		name           string
+		paramID        string
+		userID         int
+		json           string
+		expectedStatus int
+	}{
+		{"valid", "1", 1, `{"password":"new-secret"}`, http.StatusNoContent},
+		{"not found", "100", 1, `{"password":"new-secret"}`, http.StatusNotFound},
+		{"deleted user", "3", 1, `{"password":"new-secret"}`, http.StatusNotFound},
+		{"no password", "1", 1, `{"password":""}`, http.StatusUnprocessableEntity},
+		{"72 bytes", "1", 1, `{"password":"` + strings.Repeat("é", 36) + `"}`, http.StatusNoContent},
+		{"over 72 bytes in fewer characters", "1", 1, `{"password":"` + strings.Repeat("é", 37) + `"}`, http.StatusUnprocessableEntity},
+		{"bad URL param", "Y", 1, `{"password":"new-secret"}`, http.StatusBadRequest},
+		{"not an admin", "1", 2, `{"password":"new-secret"}`, http.StatusForbidden},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		repo := dbrepo.NewTestDBRepo()
+		app.DB = repo
+
+		req, _ := http.NewRequest("PUT", "/", strings.NewReader(e.json))
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+		ctx = context.WithValue(ctx, contextUserIDKey, e.userID)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.resetUserPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		user, _ := repo.GetUser(context.Background(), 1)
+		keys, _ := repo.AllAPIKeys(context.Background(), 1)
+		var sent struct{ Password string }
+		_ = json.Unmarshal([]byte(e.json), &sent)
+		changed, _ := user.PasswordMatches(sent.Password)
+
+		if e.expectedStatus != http.StatusNoContent {
+			if changed {
+				t.Errorf("%s: password changed, but should not have", e.name)
+			}
+			continue
+		}
+
+		if !changed {
+			t.Errorf("%s: password was not changed", e.name)
+		}
+		for _, key := range keys {
+			if !key.Revoked() {
+				t.Errorf("%s: api key %d was not revoked", e.name, key.ID)
+			}
+		}
+	}
+}
+
+// brokenDB fails every user lookup, as a database that is down would
+type brokenDB struct {
+	*dbrepo.MemoryDBRepo
+}
+
+func (m *brokenDB) AllUsers(ctx context.Context) ([]*data.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (m *brokenDB) EachUser(ctx context.Context, fn func(u *data.User) error) error {
+	return errors.New("connection refused")
+}
+
+func (m *brokenDB) GetUser(ctx context.Context, id int) (*data.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (m *brokenDB) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func Test_app_databaseDown(t *testing.T) {
+	oldDB := app.DB
+	app.DB = &brokenDB{dbrepo.NewTestDBRepo()}
+	defer func() { app.DB = oldDB }()
+
+	var tests = []struct {
+		name    string
+		method  string
+		json    string
+		handler http.HandlerFunc
+	}{
+		{"allUsers", "GET", "", app.allUsers},
+		{"getUser", "GET", "", app.getUser},
+		{"authenticate", "POST", `{"email":"admin@example.com","password":"secret"}`, app.authenticate},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, "/", strings.NewReader(e.json))
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		// an outage is our problem, not the client's, and its details stay in the log
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusInternalServerError, rr.Code)
+		}
+
+		if strings.Contains(rr.Body.String(), "connection refused") {
+			t.Errorf("%s: database error leaked to the client: %s", e.name, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_allUsersFormats(t *testing.T) {
+	var tests = []struct {
+		name                string
+		accept              string
+		expectedStatus      int
+		expectedContentType string
+		expectedFirstLine   string
+	}{
+		{"no accept", "", http.StatusOK, "application/json", `[{"id":1,`},
+		{"json", "application/json", http.StatusOK, "application/json", `[{"id":1,`},
+		{"csv", "text/csv", http.StatusOK, "text/csv; charset=utf-8", "id,first_name,last_name,email,is_admin"},
+		{"ndjson", "application/x-ndjson", http.StatusOK, "application/x-ndjson", `{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com","is_admin":1}`},
+		{"preferred", "application/json;q=0.5, text/csv", http.StatusOK, "text/csv; charset=utf-8", "id,first_name,last_name,email,is_admin"},
+		{"wildcard", "text/*", http.StatusOK, "text/csv; charset=utf-8", "id,first_name,last_name,email,is_admin"},
+		{"browser", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, "application/json", `[{"id":1,`},
+		{"not acceptable", "text/html", http.StatusNotAcceptable, problemContentType, `{"type":"about:blank"`},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		if e.accept != "" {
+			req.Header.Set("Accept", e.accept)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Header().Get("Content-Type") != e.expectedContentType {
+			t.Errorf("%s: expected content type %s, but got %s", e.name, e.expectedContentType, rr.Header().Get("Content-Type"))
+		}
+
+		firstLine, _, _ := strings.Cut(rr.Body.String(), "\n")
+		if !strings.HasPrefix(firstLine, e.expectedFirstLine) {
+			t.Errorf("%s: expected the body to start with %s, but got %s", e.name, e.expectedFirstLine, firstLine)
+		}
+
+		download := strings.HasPrefix(e.expectedContentType, "text/csv") || e.expectedContentType == "application/x-ndjson"
+		if download != strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("%s: unexpected Content-Disposition %q", e.name, rr.Header().Get("Content-Disposition"))
+		}
+	}
+}
+
+func Test_app_searchUsers(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []int
+	}{
+		{"prefix", "?q=adm", http.StatusOK, []int{1}},
+		{"both words", "?q=mfa+us", http.StatusOK, []int{2}},
+		{"shared last name", "?q=user", http.StatusOK, []int{1, 2}},
+		{"limit", "?q=user&limit=1", http.StatusOK, []int{1}},
+		{"deleted users are not found", "?q=deleted", http.StatusOK, []int{}},
+		{"no query", "", http.StatusOK, []int{}},
+		{"limit too large", "?q=user&limit=51", http.StatusBadRequest, nil},
+		{"bad limit", "?q=user&limit=x", http.StatusBadRequest, nil},
+		{"query too long", "?q=" + strings.Repeat("a", 101), http.StatusBadRequest, nil},
+	}
+
+	// other tests restore the deleted user
+	app.DB = dbrepo.NewTestDBRepo()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/search"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.searchUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+			continue
+		}
+
+		if e.expectedIDs == nil {
+			continue
+		}
+
+		var users []data.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		ids := []int{}
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(e.expectedIDs) {
+			t.Errorf("%s: expected users %v, but got %v", e.name, e.expectedIDs, ids)
+		}
+	}
+}
+
+func Test_app_exportUsersDatabaseDown(t *testing.T) {
+	oldDB := app.DB
+	app.DB = &brokenDB{dbrepo.NewTestDBRepo()}
+	defer func() { app.DB = oldDB }()
+
+	req, _ := http.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+	// nothing was sent yet, so the client learns the export failed
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") != problemContentType {
+		t.Errorf("expected a 500 problem, but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	if rr.Header().Get("Content-Disposition") != "" {
+		t.Error("a failed export is still sent as a download")
+	}
+
+	if strings.Contains(rr.Body.String(), "connection refused") {
+		t.Error("the database error was sent to the client")
+	}
+}
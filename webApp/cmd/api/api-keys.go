@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"webApp/pkg/data"
+	"webApp/pkg/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NewAPIKey is the payload for creating an API key.
+type NewAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (k *NewAPIKey) Validate(v *validator.Validator) {
+	v.Required("name", k.Name)
+	v.Check(len(k.Scopes) > 0, "scopes", "at least one scope is required")
+	for _, scope := range k.Scopes {
+		v.Check(data.ValidScope(scope), "scopes", "unknown scope "+scope)
+	}
+}
+
+// CreatedAPIKey is returned once, when a key is created; it is the only time
+// the key itself is ever sent to the client.
+type CreatedAPIKey struct {
+	Key string `json:"key"`
+	data.APIKey
+}
+
+// verifyAPIKey looks up a key by its hash, and records that it was used.
+func (app *application) verifyAPIKey(ctx context.Context, key string) (*data.APIKey, error) {
+	apiKey, err := app.DB.GetAPIKeyByHash(ctx, data.HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey.Revoked() {
+		return nil, errors.New("api key revoked")
+	}
+
+	// last used is informational only, so it must not block the request
+	if err := app.DB.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		log.Println("could not update api key last used:", err)
+	}
+
+	return apiKey, nil
+}
+
+// userIDFromContext returns the id of the authenticated user, as set by authRequired
+func userIDFromContext(r *http.Request) (int, bool) {
+	userID, ok := r.Context().Value(contextUserIDKey).(int)
+	return userID, ok
+}
+
+// keyManager returns the user allowed to manage API keys in this request.
+// Keys are managed with a user's JWT; an API key cannot create or revoke keys.
+func (app *application) keyManager(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if r.Context().Value(contextAPIKeyKey) != nil {
+		app.errorJSON(w, r, errors.New("api keys cannot manage api keys"), http.StatusForbidden)
+		return 0, false
+	}
+
+	userID, ok := userIDFromContext(r)
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func (app *application) allAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.keyManager(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := app.DB.AllAPIKeys(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	// no keys is an empty list, not null
+	if keys == nil {
+		keys = []*data.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+func (app *application) insertAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.keyManager(w, r)
+	if !ok {
+		return
+	}
+
+	var payload NewAPIKey
+	if !app.readValidJSON(w, r, &payload) {
+		return
+	}
+
+	key, prefix, hash, err := data.GenerateAPIKey()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := data.APIKey{
+		UserID: userID,
+		Name: payload.Name,
+		Prefix: prefix,
+		Hash: hash,
+		Scopes: payload.Scopes,
+	}
+
+	apiKey.ID, err = app.DB.InsertAPIKey(r.Context(), apiKey)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, CreatedAPIKey{Key: key, APIKey: apiKey})
+}
+
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.keyManager(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(r.Context(), userID, keyID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
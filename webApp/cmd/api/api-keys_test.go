@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_apiKeyHandlers(t *testing.T) {
+	var tests = []struct {
+		name           string
+		method         string
+		json           string
+		paramID        string
+		withAPIKey     bool
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{"allAPIKeys", "GET", "", "", false, app.allAPIKeys, http.StatusOK},
+		{"allAPIKeys with api key", "GET", "", "", true, app.allAPIKeys, http.StatusForbidden},
+		{"insertAPIKey valid", "POST", `{"name":"batch","scopes":["users:read"]}`, "", false, app.insertAPIKey, http.StatusCreated},
+		{"insertAPIKey no name", "POST", `{"scopes":["users:read"]}`, "", false, app.insertAPIKey, http.StatusUnprocessableEntity},
+		{"insertAPIKey no scopes", "POST", `{"name":"batch"}`, "", false, app.insertAPIKey, http.StatusUnprocessableEntity},
+		{"insertAPIKey bad scope", "POST", `{"name":"batch","scopes":["admin"]}`, "", false, app.insertAPIKey, http.StatusUnprocessableEntity},
+		{"insertAPIKey with api key", "POST", `{"name":"batch","scopes":["users:read"]}`, "", true, app.insertAPIKey, http.StatusForbidden},
+		{"revokeAPIKey valid", "DELETE", "", "1", false, app.revokeAPIKey, http.StatusNoContent},
+		{"revokeAPIKey not found", "DELETE", "", "100", false, app.revokeAPIKey, http.StatusNotFound},
+		{"revokeAPIKey bad URL param", "DELETE", "", "Y", false, app.revokeAPIKey, http.StatusBadRequest},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		var req *http.Request
+		if e.json == "" {
+			req, _ = http.NewRequest(e.method, "/", nil)
+		} else {
+			req, _ = http.NewRequest(e.method, "/", strings.NewReader(e.json))
+		}
+
+		ctx := context.WithValue(req.Context(), contextUserIDKey, 1)
+		if e.withAPIKey {
+			ctx = context.WithValue(ctx, contextAPIKeyKey, &data.APIKey{ID: 2, UserID: 1})
+		}
+		if e.paramID != "" {
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("keyID", e.paramID)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_insertAPIKeyReturnsKeyOnce(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"name":"batch","scopes":["users:read","users:write"]}`))
+	req = req.WithContext(context.WithValue(req.Context(), contextUserIDKey, 1))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.insertAPIKey)
+	handler.ServeHTTP(rr, req)
+
+	var created CreatedAPIKey
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(created.Key, created.Prefix) || !strings.HasPrefix(created.Key, "wak_") {
+		t.Errorf("unexpected key %q with prefix %q", created.Key, created.Prefix)
+	}
+
+	if strings.Contains(rr.Body.String(), data.HashAPIKey(created.Key)) {
+		t.Error("key hash should not be sent to the client")
+	}
+}
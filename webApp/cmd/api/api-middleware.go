@@ -1,29 +1,115 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webApp/pkg/data"
 
-func (app *application) enableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8090")
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
-			return
-		} else {
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type contextKey string
+
+// contextUserIDKey holds the id of the authenticated user; contextAPIKeyKey
+// holds the *data.APIKey when the request was authenticated with an API key.
+const contextUserIDKey contextKey = "user_id"
+const contextAPIKeyKey contextKey = "api_key"
+
+// requestID gives every request an id, the client's X-Request-Id when it sent
+// one, and sends it back, so that a problem a client reports can be found in
+// the logs.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// legacyDeprecation is when the routes from before versioning were deprecated
+var legacyDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecated marks the responses of routes that are going away, in favour of
+// the same path under successor: Deprecation (RFC 9745) says since when, Sunset
+// (RFC 8594) until when they work, and Link where to go instead.
+func (app *application) deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyDeprecation.Unix(), 10))
+			if !app.LegacySunset.IsZero() {
+				w.Header().Set("Sunset", app.LegacySunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", "<"+successor+r.URL.Path+">; rel=\"successor-version\"")
 			next.ServeHTTP(w, r)
-		}
-	})
+		})
+	}
 }
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderandVerify(w, r)
+		w.Header().Add("Vary", "X-API-Key")
+
+		// machine clients authenticate with an API key instead of a JWT
+		if key := apiKeyFromHeader(r); key != "" {
+			apiKey, err := app.verifyAPIKey(r.Context(), key)
+			if err != nil {
+				app.errorJSON(w, r, errors.New("invalid api key"), http.StatusUnauthorized)
+				return
+			}
+
+			if !apiKey.HasScope(scopeForMethod(r.Method)) {
+				app.errorJSON(w, r, errors.New("api key lacks the "+scopeForMethod(r.Method)+" scope"), http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), contextUserIDKey, apiKey.UserID)
+			ctx = context.WithValue(ctx, contextAPIKeyKey, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		_, claims, err := app.getTokenFromHeaderandVerify(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextUserIDKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 		return
 	})
-}
\ No newline at end of file
+}
+
+// apiKeyFromHeader returns the API key sent in either the X-API-Key header or
+// an "Authorization: ApiKey <key>" header, or "" if there is none.
+func apiKeyFromHeader(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+		return headerParts[1]
+	}
+
+	return ""
+}
+
+// scopeForMethod returns the scope an API key needs for a request method
+func scopeForMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return data.ScopeUsersRead
+	default:
+		return data.ScopeUsersWrite
+	}
+}
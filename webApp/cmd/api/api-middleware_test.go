@@ -5,35 +5,132 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
 )
 
 func Test_app_enableCORS(t *testing.T) {
-	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	oldCORS := app.CORS
+	defer func() { app.CORS = oldCORS }()
+	app.CORS = defaultCORS()
+	app.CORS.AllowedOrigins = []string{"http://localhost:8090", "https://*.example.com"}
 
 	var tests = []struct{
-		name string
-		method string
-		expectHeader bool
+		name           string
+		method         string
+		origin         string
+		preflight      bool
+		expectedStatus int
+		expectedOrigin string
 	}{
-		{"preflight", "OPTIONS", true},
-		{"get", "GET", false},
+		{"same origin, no Origin header", "GET", "", false, http.StatusOK, ""},
+		{"allowed origin", "GET", "http://localhost:8090", false, http.StatusOK, "http://localhost:8090"},
+		{"allowed origin, preflight", "OPTIONS", "http://localhost:8090", true, http.StatusNoContent, "http://localhost:8090"},
+		{"pattern", "POST", "https://admin.example.com", false, http.StatusOK, "https://admin.example.com"},
+		{"pattern, preflight", "OPTIONS", "https://admin.example.com", true, http.StatusNoContent, "https://admin.example.com"},
+		{"rejected origin", "GET", "https://evil.com", false, http.StatusOK, ""},
+		{"rejected origin, preflight", "OPTIONS", "https://evil.com", true, http.StatusForbidden, ""},
+		{"pattern without subdomain", "GET", "https://example.com", false, http.StatusOK, ""},
+		{"pattern with another scheme", "GET", "http://admin.example.com", false, http.StatusOK, ""},
+		{"options without preflight", "OPTIONS", "http://localhost:8090", false, http.StatusOK, "http://localhost:8090"},
 	}
 
 	for _, e := range tests {
 		handlerToTest := app.enableCORS(nextHandler)
 
 		req := httptest.NewRequest(e.method, "http://testing", nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.preflight {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
 		rr := httptest.NewRecorder()
 
 		handlerToTest.ServeHTTP(rr, req)
 
-		if e.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") == "" {
-			t.Errorf("%s: expected header, but did not find it", e.name)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != e.expectedOrigin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, but got %q", e.name, e.expectedOrigin, got)
+		}
+
+		allowed := e.expectedOrigin != ""
+
+		// credentials go with every response to an allowed origin, not only preflights
+		if allowed != (rr.Header().Get("Access-Control-Allow-Credentials") == "true") {
+			t.Errorf("%s: unexpected Access-Control-Allow-Credentials %q", e.name, rr.Header().Get("Access-Control-Allow-Credentials"))
+		}
+
+		if allowed && e.preflight != (rr.Header().Get("Access-Control-Allow-Methods") != "") {
+			t.Errorf("%s: unexpected Access-Control-Allow-Methods %q", e.name, rr.Header().Get("Access-Control-Allow-Methods"))
 		}
 
-		if !e.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") != "" {
-			t.Errorf("%s: expected no header, but got one", e.name)
+		if allowed && e.preflight && rr.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: expected Access-Control-Max-Age 600, but got %q", e.name, rr.Header().Get("Access-Control-Max-Age"))
+		}
+
+		if allowed && !e.preflight && rr.Header().Get("Access-Control-Expose-Headers") == "" {
+			t.Errorf("%s: expected Access-Control-Expose-Headers", e.name)
+		}
+
+		if e.origin != "" && rr.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin, but got %q", e.name, rr.Header().Values("Vary"))
+		}
+	}
+}
+
+func Test_CORSConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		origins       []string
+		credentials   bool
+		maxAge        time.Duration
+		errorExpected bool
+	}{
+		{"default", []string{"http://localhost:8090"}, true, time.Minute, false},
+		{"any origin", []string{"*"}, false, 0, false},
+		{"any origin with credentials", []string{"*"}, true, 0, true},
+		{"two wildcards", []string{"https://*.*.example.com"}, false, 0, true},
+		{"negative max age", []string{"http://localhost:8090"}, false, -time.Second, true},
+	}
+
+	for _, e := range tests {
+		c := CORSConfig{AllowedOrigins: e.origins, AllowCredentials: e.credentials, MaxAge: e.maxAge}
+		err := c.Validate()
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected an error, but got none", e.name)
+		}
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+	}
+
+	var origins = []struct {
+		allowed string
+		origin  string
+		expect  bool
+	}{
+		{"*", "https://anything.com", true},
+		{"https://app.example.com", "https://APP.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://evil.com:443.example.com", false},
+		{"http://localhost:*", "http://localhost:3000", true},
+		{"http://localhost:*", "http://localhost:", false},
+	}
+
+	for _, e := range origins {
+		c := CORSConfig{AllowedOrigins: []string{e.allowed}}
+		if c.allowsOrigin(e.origin) != e.expect {
+			t.Errorf("%s allowing %s: expected %t", e.allowed, e.origin, e.expect)
 		}
 	}
 }
@@ -79,4 +176,46 @@ func Test_app_authRequired(t *testing.T) {
 			t.Errorf("%s: did not get code 402, and should have", e.name)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func Test_app_authRequiredWithAPIKey(t *testing.T) {
+	var tests = []struct {
+		name           string
+		method         string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"read key, X-API-Key", "GET", "X-API-Key", dbrepo.TestAPIKeyRead, http.StatusOK},
+		{"read key, Authorization", "GET", "Authorization", "ApiKey " + dbrepo.TestAPIKeyRead, http.StatusOK},
+		{"read key cannot write", "DELETE", "X-API-Key", dbrepo.TestAPIKeyRead, http.StatusForbidden},
+		{"write key can write", "PATCH", "X-API-Key", dbrepo.TestAPIKeyWrite, http.StatusOK},
+		{"revoked key", "GET", "X-API-Key", dbrepo.TestAPIKeyRevoked, http.StatusUnauthorized},
+		{"unknown key", "GET", "X-API-Key", "wak_nope", http.StatusUnauthorized},
+		{"unknown key, Authorization", "GET", "Authorization", "ApiKey wak_nope", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		var gotUserID int
+		var gotKey bool
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUserID, _ = userIDFromContext(r)
+			_, gotKey = r.Context().Value(contextAPIKeyKey).(*data.APIKey)
+		})
+
+		req, _ := http.NewRequest(e.method, "/", nil)
+		req.Header.Set(e.header, e.value)
+		rr := httptest.NewRecorder()
+
+		handlerToTest := app.authRequired(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if e.expectedStatus == http.StatusOK && (gotUserID != 1 || !gotKey) {
+			t.Errorf("%s: expected user 1 and api key in context, got %d %t", e.name, gotUserID, gotKey)
+		}
+	}
+}
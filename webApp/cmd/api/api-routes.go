@@ -11,21 +11,43 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// register middleware
+	mux.Use(app.requestID)
 	mux.Use(middleware.Recoverer)
 	// mux.Use(app.enableCORS)
 	mux.Use(app.enableCORS)
 
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./html/"))))
 
+	// each version of the API is mounted under its own prefix; a new version
+	// gets a routes function of its own, next to v1Routes
+	mux.Route("/v1", app.v1Routes)
+
+	// the routes from before versioning, the same as /v1, until they are sunset
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.deprecated("/v1"))
+		app.v1Routes(mux)
+	})
+
+	return mux
+}
+
+// v1Routes registers version 1 of the API on mux
+func (app *application) v1Routes(mux chi.Router) {
+	mux.Get("/openapi.json", app.openAPI)
+
 	// only for SPA
 	mux.Route("/web", func(mux chi.Router) {
 		mux.Post("/auth", app.authenticate)
+		mux.Post("/auth/mfa", app.authenticateMFA)
 		mux.Get("/refresh-token", app.refreshUsingCookie)
 		mux.Get("/logout", app.deleteRefreshCookie)
 	})
 
 	//authentication routes - auth handler, refresh
 	mux.Post("/auth", app.authenticate)
+	mux.Post("/auth/mfa", app.authenticateMFA)
+	mux.Get("/auth/oidc", app.loginOIDC)
+	mux.Get("/auth/oidc/callback", app.oidcCallback)
 	mux.Post("/refresh-token", app.refresh)
 
 	// protected routes
@@ -34,11 +56,31 @@ func (app *application) routes() http.Handler {
 		mux.Use(app.authRequired)
 
 		mux.Get("/", app.allUsers)
+		mux.Get("/search", app.searchUsers)
 		mux.Get("/{userID}", app.getUser)
 		mux.Delete("/{userID}", app.deleteUser)
+		mux.Post("/{userID}/restore", app.restoreUser)
+		mux.Put("/{userID}/password", app.resetUserPassword)
 		mux.Put("/", app.insertUser)
+		mux.Post("/import", app.importUsers)
 		mux.Patch("/", app.updateUser)
+		mux.Put("/{userID}", app.replaceUser)
+		mux.Patch("/{userID}", app.patchUser)
 	})
 
-	return mux
-}
\ No newline at end of file
+	// api keys for machine clients; managed with a user's JWT
+	mux.Route("/api-keys", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+
+		mux.Get("/", app.allAPIKeys)
+		mux.Post("/", app.insertAPIKey)
+		mux.Delete("/{keyID}", app.revokeAPIKey)
+	})
+
+	// audit log; admin only
+	mux.Route("/audit", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+
+		mux.Get("/", app.allAuditEvents)
+	})
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -13,13 +15,31 @@ func Test_application_routes(t *testing.T) {
 		route string
 		method string
 	}{
+		{"/openapi.json", "GET"},
+		{"/web/auth", "POST"},
+		{"/web/auth/mfa", "POST"},
+		{"/web/refresh-token", "GET"},
+		{"/web/logout", "GET"},
 		{"/auth", "POST"},
+		{"/auth/mfa", "POST"},
+		{"/auth/oidc", "GET"},
+		{"/auth/oidc/callback", "GET"},
 		{"/refresh-token", "POST"},
 		{"/users/", "GET"},
 		{"/users/{userID}", "GET"},
 		{"/users/{userID}", "DELETE"},
+		{"/users/{userID}/restore", "POST"},
+		{"/users/import", "POST"},
+		{"/users/search", "GET"},
+		{"/users/{userID}/password", "PUT"},
 		{"/users/", "PATCH"},
 		{"/users/", "PUT"},
+		{"/users/{userID}", "PATCH"},
+		{"/users/{userID}", "PUT"},
+		{"/api-keys/", "GET"},
+		{"/api-keys/", "POST"},
+		{"/api-keys/{keyID}", "DELETE"},
+		{"/audit/", "GET"},
 	}
 
 	mux := app.routes()
@@ -27,13 +47,62 @@ func Test_application_routes(t *testing.T) {
 	chiRoutes := mux.(chi.Routes)
 
 	for _, route := range registered {
-		// check to see if the route exits
+		// check to see if the route exits, in v1 and from before versioning
+		if !routeExists("/v1"+route.route, route.method, chiRoutes) {
+			t.Errorf("route /v1%s is not registered", route.route)
+		}
 		if !routeExists(route.route, route.method, chiRoutes) {
 			t.Errorf("route %s is not registered", route.route)
 		}
 	}
 }
 
+func Test_app_deprecatedRoutes(t *testing.T) {
+	app.LegacySunset = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+	defer func() { app.LegacySunset = time.Time{} }()
+
+	var tests = []struct {
+		name             string
+		path             string
+		expectDeprecated bool
+	}{
+		{"v1", "/v1/openapi.json", false},
+		{"before versioning", "/openapi.json", true},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.path, nil)
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, but got %d", e.name, rr.Code)
+		}
+
+		if !e.expectDeprecated {
+			if rr.Header().Get("Deprecation") != "" || rr.Header().Get("Sunset") != "" {
+				t.Errorf("%s: expected no deprecation headers, but got %v", e.name, rr.Header())
+			}
+			continue
+		}
+
+		if rr.Header().Get("Deprecation") != "@1792368000" {
+			t.Errorf("%s: expected Deprecation @1792368000, but got %q", e.name, rr.Header().Get("Deprecation"))
+		}
+
+		if rr.Header().Get("Sunset") != "Mon, 19 Apr 2027 00:00:00 GMT" {
+			t.Errorf("%s: unexpected Sunset %q", e.name, rr.Header().Get("Sunset"))
+		}
+
+		if rr.Header().Get("Link") != `</v1/openapi.json>; rel="successor-version"` {
+			t.Errorf("%s: unexpected Link %q", e.name, rr.Header().Get("Link"))
+		}
+	}
+}
+
 func routeExists(testRoute, testMethod string, chiRoutes chi.Routes) bool {
 	found := false
 
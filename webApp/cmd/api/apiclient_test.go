@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"webApp/pkg/apiclient"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+)
+
+// Test_apiclient runs the client in pkg/apiclient against routes()
+func Test_apiclient(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	ctx := context.Background()
+	c := apiclient.New(apiclient.Config{BaseURL: server.URL})
+
+	if _, err := c.Users(ctx); !errors.Is(err, apiclient.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized before logging in, but got %v", err)
+	}
+
+	if err := c.Login(ctx, "admin@example.com", "wrong"); !errors.Is(err, apiclient.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for a wrong password, but got %v", err)
+	}
+
+	if err := c.Login(ctx, "admin@example.com", "secret"); err != nil {
+		t.Fatal("login failed:", err)
+	}
+
+	users, err := c.Users(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected the 2 fixture users, but got %v, %v", users, err)
+	}
+
+	user, err := c.User(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "mfa@example.com" || user.ETag != userETag(1) {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	if _, err := c.User(ctx, 100); !errors.Is(err, apiclient.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, but got %v", err)
+	}
+
+	stale := *user
+
+	user.FirstName = "Jane"
+	if err := c.UpdateUser(ctx, user); err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if user.ETag != userETag(2) {
+		t.Errorf("expected the new ETag %s, but got %s", userETag(2), user.ETag)
+	}
+
+	stale.LastName = "Smith"
+	if err := c.UpdateUser(ctx, &stale); !errors.Is(err, apiclient.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch for a stale update, but got %v", err)
+	}
+
+	if err := c.PatchUser(ctx, user, map[string]any{"last_name": "Doe"}); err != nil {
+		t.Fatal("patch failed:", err)
+	}
+
+	stored, _ := app.DB.GetUser(ctx, 2)
+	if stored.FirstName != "Jane" || stored.LastName != "Doe" || user.LastName != "Doe" || user.ETag != userETag(stored.Version) {
+		t.Errorf("expected Jane Doe at %s, but stored %+v and the client has %+v", user.ETag, stored, user)
+	}
+
+	err = c.CreateUser(ctx, apiclient.NewUser{FirstName: "Jack", LastName: "Smith", Email: "jack", Password: "secret"})
+	var apiErr *apiclient.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, apiclient.ErrInvalid) || len(apiErr.FieldErrors["email"]) == 0 {
+		t.Errorf("expected a validation error for email, but got %v", err)
+	}
+
+	err = c.CreateUser(ctx, apiclient.NewUser{FirstName: "Jack", LastName: "Smith", Email: "admin@example.com", Password: "secret"})
+	if !errors.Is(err, apiclient.ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate email, but got %v", err)
+	}
+
+	if err := c.CreateUser(ctx, apiclient.NewUser{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", Password: "secret"}); err != nil {
+		t.Error("create failed:", err)
+	}
+
+	if err := c.DeleteUser(ctx, 2); err != nil {
+		t.Error("delete failed:", err)
+	}
+
+	if err := c.RestoreUser(ctx, 2); err != nil {
+		t.Error("restore failed:", err)
+	}
+
+	if err := c.ResetPassword(ctx, 2, "new secret"); err != nil {
+		t.Error("password reset failed:", err)
+	}
+
+	found, err := c.SearchUsers(ctx, "adm", 5)
+	if err != nil || len(found) != 1 || found[0].Email != "admin@example.com" {
+		t.Errorf("expected to find the admin, but got %v, %v", found, err)
+	}
+
+	newUsers := []apiclient.NewUser{
+		{FirstName: "Jill", LastName: "Smith", Email: "jill@example.com", Password: "secret"},
+		{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", Password: "secret"},
+	}
+	for _, dryRun := range []bool{true, false} {
+		report, err := c.ImportUsers(ctx, newUsers, dryRun)
+		if err != nil {
+			t.Fatal("import failed:", err)
+		}
+		if report.DryRun != dryRun || report.Created != 1 || report.Skipped != 1 || report.Rows[1].Status != "skipped" {
+			t.Errorf("unexpected import report %+v", report)
+		}
+	}
+}
+
+func Test_apiclientMFA(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	ctx := context.Background()
+	c := apiclient.New(apiclient.Config{BaseURL: server.URL})
+
+	err := c.Login(ctx, "mfa@example.com", "secret")
+	var mfaErr *apiclient.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("expected an MFA challenge, but got %v", err)
+	}
+
+	if err := c.LoginMFA(ctx, mfaErr.Token, "00000-00000"); !errors.Is(err, apiclient.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for a wrong code, but got %v", err)
+	}
+
+	if err := c.LoginMFA(ctx, mfaErr.Token, dbrepo.TestRecoveryCode); err != nil {
+		t.Fatal("mfa login failed:", err)
+	}
+
+	if _, err := c.User(ctx, 2); err != nil {
+		t.Error("request after mfa login failed:", err)
+	}
+}
+
+func Test_apiclientRenewsTokens(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	oldAccessTime, oldRefreshTime := jwtTokenExpiry, refreshTokenExpiry
+	defer func() { jwtTokenExpiry, refreshTokenExpiry = oldAccessTime, oldRefreshTime }()
+
+	// the client only holds tokens, so it has to renew them without signing in
+	var tests = []struct {
+		name          string
+		accessExpiry  time.Duration
+		refreshExpiry time.Duration
+		accessToken   string
+	}{
+		{"refresh token about to expire", oldAccessTime, 10 * time.Second, expiredToken},
+		{"access token expired", -time.Minute, oldRefreshTime, ""},
+		{"access token about to expire", 20 * time.Second, oldRefreshTime, ""},
+	}
+
+	for _, e := range tests {
+		jwtTokenExpiry, refreshTokenExpiry = e.accessExpiry, e.refreshExpiry
+		tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+		jwtTokenExpiry, refreshTokenExpiry = oldAccessTime, oldRefreshTime
+
+		if e.accessToken != "" {
+			tokens.Token = e.accessToken
+		}
+
+		c := apiclient.New(apiclient.Config{BaseURL: server.URL})
+		c.SetTokens(apiclient.TokenPairs{Token: tokens.Token, RefreshToken: tokens.RefreshToken})
+
+		if _, err := c.Users(context.Background()); err != nil {
+			t.Errorf("%s: expected the client to renew its tokens, but got %s", e.name, err)
+			continue
+		}
+
+		if c.Tokens().Token == tokens.Token {
+			t.Errorf("%s: the client still has its old access token", e.name)
+		}
+	}
+}
+
+func Test_apiclientAPIKey(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	ctx := context.Background()
+
+	c := apiclient.New(apiclient.Config{BaseURL: server.URL, APIKey: dbrepo.TestAPIKeyRead})
+	if _, err := c.Users(ctx); err != nil {
+		t.Error("read with a read only key failed:", err)
+	}
+
+	if err := c.DeleteUser(ctx, 2); !errors.Is(err, apiclient.ErrForbidden) {
+		t.Errorf("expected ErrForbidden deleting with a read only key, but got %v", err)
+	}
+}
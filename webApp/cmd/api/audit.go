@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// audit records an event in the audit log. The request supplies the client's
+// address, and the API key when one was used. Failing to record an event is
+// logged, but does not fail the request.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	e.IP = app.ClientIP.IP(r)
+
+	if apiKey, ok := r.Context().Value(contextAPIKeyKey).(*data.APIKey); ok {
+		if e.Details == nil {
+			e.Details = map[string]any{}
+		}
+		e.Details["api_key_id"] = apiKey.ID
+	}
+
+	if _, err := app.DB.InsertAuditEvent(r.Context(), e); err != nil {
+		log.Println("could not record audit event:", err)
+	}
+}
+
+// requireAdmin writes an error and returns false unless the authenticated user is an admin.
+func (app *application) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userID, ok := userIDFromContext(r)
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return false
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		app.dbErrorJSON(w, r, err)
+		return false
+	}
+	if user == nil || user.IsAdmin != 1 {
+		app.errorJSON(w, r, errors.New("admin only"), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// allAuditEvents lists audit events, newest first. The query parameters
+// actor_id, target_user_id, action, since, until (RFC 3339) and limit filter
+// the list.
+func (app *application) allAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	events, err := app.DB.AllAuditEvents(r.Context(), filter)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	// no events is an empty list, not null
+	if events == nil {
+		events = []*data.AuditEvent{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, events)
+}
+
+func auditFilterFromQuery(r *http.Request) (data.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := data.AuditFilter{
+		Action: q.Get("action"),
+		Limit: defaultAuditLimit,
+	}
+
+	var err error
+	if v := q.Get("actor_id"); v != "" {
+		if filter.ActorID, err = strconv.Atoi(v); err != nil {
+			return filter, errors.New("invalid actor_id")
+		}
+	}
+
+	if v := q.Get("target_user_id"); v != "" {
+		if filter.TargetUserID, err = strconv.Atoi(v); err != nil {
+			return filter, errors.New("invalid target_user_id")
+		}
+	}
+
+	if v := q.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid since, expected RFC 3339")
+		}
+	}
+
+	if v := q.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid until, expected RFC 3339")
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(maxAuditLimit))
+		}
+	}
+
+	return filter, nil
+}
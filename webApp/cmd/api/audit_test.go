@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webApp/pkg/clientip"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_allAuditEvents(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		userID         int
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"admin", "", 1, http.StatusOK, 2},
+		{"filter by action", "?action=user.deleted", 1, http.StatusOK, 1},
+		{"filter by target", "?target_user_id=7", 1, http.StatusOK, 1},
+		{"filter by actor", "?actor_id=2", 1, http.StatusOK, 0},
+		{"time range", "?since=2023-01-01T00:00:00Z&until=2030-01-01T00:00:00Z", 1, http.StatusOK, 2},
+		{"bad actor_id", "?actor_id=x", 1, http.StatusBadRequest, 0},
+		{"bad since", "?since=yesterday", 1, http.StatusBadRequest, 0},
+		{"limit too large", "?limit=5000", 1, http.StatusBadRequest, 0},
+		{"not an admin", "", 2, http.StatusForbidden, 0},
+		{"no user", "", 0, http.StatusUnauthorized, 0},
+	}
+
+	// other tests add events, so count against the fixtures alone
+	oldDB := app.DB
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/audit/"+e.query, nil)
+		if e.userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), contextUserIDKey, e.userID))
+		}
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.allAuditEvents)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var events []*data.AuditEvent
+		_ = json.Unmarshal(rr.Body.Bytes(), &events)
+		if len(events) != e.expectedCount {
+			t.Errorf("%s: expected %d events but got %d", e.name, e.expectedCount, len(events))
+		}
+	}
+}
+
+func Test_changedFields(t *testing.T) {
+	old := &data.User{FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1}
+	updated := *old
+	updated.Email = "new@example.com"
+	updated.IsAdmin = 0
+
+	changed := changedFields(old, &updated)
+	if len(changed) != 2 || changed[0] != "email" || changed[1] != "is_admin" {
+		t.Errorf("unexpected changed fields %v", changed)
+	}
+}
+
+func Test_app_auditClientIP(t *testing.T) {
+	oldDB, oldClientIP := app.DB, app.ClientIP
+	defer func() { app.DB, app.ClientIP = oldDB, oldClientIP }()
+
+	var tests = []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"no trusted proxies", nil, "203.0.113.7:1234", "1.2.3.4", "203.0.113.7"},
+		{"from a trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"past a trusted proxy", []string{"10.0.0.0/8"}, "203.0.113.7:1234", "1.2.3.4", "203.0.113.7"},
+	}
+
+	for _, e := range tests {
+		app.DB = dbrepo.NewTestDBRepo()
+		app.ClientIP, _ = clientip.New(e.proxies)
+
+		req, _ := http.NewRequest("POST", "/auth", nil)
+		req.RemoteAddr = e.remoteAddr
+		req.Header.Set("X-Forwarded-For", e.forwarded)
+		app.audit(req, data.AuditEvent{Action: data.AuditLoginFailed})
+
+		events, _ := app.DB.AllAuditEvents(context.Background(), data.AuditFilter{Action: data.AuditLoginFailed, Limit: 10})
+		if len(events) != 1 || events[0].IP != e.expected {
+			t.Errorf("%s: expected one event from %s, but got %+v", e.name, e.expected, events)
+		}
+	}
+}
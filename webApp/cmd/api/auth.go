@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -13,6 +15,11 @@ import (
 
 var jwtTokenExpiry = time.Minute * 15
 var refreshTokenExpiry = time.Hour * 24
+var mfaTokenExpiry = time.Minute * 5
+
+// purposeMFA marks the short lived token handed out between the password and
+// the two-factor step; it must never be accepted as an access or refresh token
+const purposeMFA = "mfa"
 
 type TokenPairs struct {
 	Token string `json:"access_token"`
@@ -21,6 +28,10 @@ type TokenPairs struct {
 
 type Claims struct {
 	Username string `json:"name"`
+	Purpose  string `json:"purpose,omitempty"`
+	// AccessExpiresAt is when the access token a refresh token was issued
+	// with expires
+	AccessExpiresAt *jwt.NumericDate `json:"access_exp,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -77,6 +88,11 @@ func (app *application) getTokenFromHeaderandVerify(w http.ResponseWriter, r *ht
 		return "", nil, errors.New("incorrect issuer")
 	}
 
+	// two-factor challenge tokens are not access tokens
+	if claims.Purpose != "" {
+		return "", nil, errors.New("invalid token purpose")
+	}
+
 	// valid tokens
 	return token, claims, nil
 }
@@ -98,7 +114,8 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	}
 
 	// set the expiry
-	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
+	accessExpiry := time.Now().Add(jwtTokenExpiry).Unix()
+	claims["exp"] = accessExpiry
 
 	// create the signed token
 	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
@@ -112,6 +129,8 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
 	// set expiry: must longer than hwt expiry
 	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	// when the access token expires, which is when refresh will take this one
+	refreshTokenClaims["access_exp"] = accessExpiry
 
 	// create signedd refresh token
 	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
@@ -125,4 +144,40 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	}
 
 	return TokenPairs, nil
-}
\ No newline at end of file
+}
+
+// generateMFAToken creates the token a client has to send back, together with
+// a two-factor code, to finish logging in.
+func (app *application) generateMFAToken(user *data.User) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["iss"] = app.Domain
+	claims["purpose"] = purposeMFA
+	claims["exp"] = time.Now().Add(mfaTokenExpiry).Unix()
+
+	return token.SignedString([]byte(app.JWTSecret))
+}
+
+// passwordHasher hashes the passwords the api hashes itself, rather than the
+// repository; like the repository, it falls back to bcrypt
+func (app *application) passwordHasher() data.PasswordHasher {
+	if app.Hasher == nil {
+		return data.BcryptHasher{Cost: data.DefaultBcryptCost}
+	}
+	return app.Hasher
+}
+
+// rehashPassword stores a new hash for password when the user's current one was
+// made with outdated parameters. A failure here does not fail the login.
+func (app *application) rehashPassword(ctx context.Context, user *data.User, password string) {
+	if app.Hasher == nil || !app.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	err := app.DB.ResetPassword(ctx, user.ID, password)
+	if err != nil {
+		log.Println("could not rehash password:", err)
+	}
+}
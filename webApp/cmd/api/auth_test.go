@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
 )
 
 func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
@@ -58,4 +61,56 @@ func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
 		}
 		app.Domain = "example.com"
 	}
-}
\ No newline at end of file
+}
+
+// resetRecorder remembers which users had their password reset
+type resetRecorder struct {
+	*dbrepo.MemoryDBRepo
+	resetIDs []int
+}
+
+func (m *resetRecorder) ResetPassword(ctx context.Context, id int, password string) error {
+	m.resetIDs = append(m.resetIDs, id)
+	return nil
+}
+
+func Test_app_authenticateRehashesPassword(t *testing.T) {
+	// the test user's hash uses bcrypt cost 14
+	var tests = []struct {
+		name         string
+		hasher       data.PasswordHasher
+		password     string
+		expectRehash bool
+	}{
+		{"current cost", data.BcryptHasher{Cost: 14}, "secret", false},
+		{"outdated cost", data.BcryptHasher{Cost: 12}, "secret", true},
+		{"algorithm changed", data.DefaultArgon2idHasher, "secret", true},
+		{"wrong password", data.BcryptHasher{Cost: 12}, "wrong", false},
+		{"no hasher configured", nil, "secret", false},
+	}
+
+	oldDB := app.DB
+	defer func() {
+		app.DB = oldDB
+		app.Hasher = nil
+	}()
+
+	for _, e := range tests {
+		recorder := &resetRecorder{MemoryDBRepo: dbrepo.NewTestDBRepo()}
+		app.DB = recorder
+		app.Hasher = e.hasher
+
+		body := `{"email":"admin@example.com","password":"` + e.password + `"}`
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(body))
+		handler := http.HandlerFunc(app.authenticate)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if e.expectRehash && (len(recorder.resetIDs) != 1 || recorder.resetIDs[0] != 1) {
+			t.Errorf("%s: expected password of user 1 to be rehashed, got %v", e.name, recorder.resetIDs)
+		}
+
+		if !e.expectRehash && len(recorder.resetIDs) != 0 {
+			t.Errorf("%s: did not expect a rehash, got %v", e.name, recorder.resetIDs)
+		}
+	}
+}
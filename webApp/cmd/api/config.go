@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"webApp/pkg/config"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+)
+
+// apiConfig are the settings of the api, loaded by config.Load.
+type apiConfig struct {
+	config.Common
+	Port      int    `config:"port" usage:"port to listen on"`
+	Domain    string `config:"domain" usage:"Domain for application, e.g. company.com"`
+	JWTSecret string `config:"jwt-secret" secret:"true" required:"true" usage:"signing secret"`
+	Database  config.Database
+	Passwords config.Passwords
+	OIDC      config.OIDC
+	Proxies   config.Proxies
+	// LegacySunset is a YYYY-MM-DD date
+	LegacySunset string `config:"legacy-sunset" usage:"date (YYYY-MM-DD) the routes without /v1 stop working, sent in their Sunset header"`
+	CORS         CORSConfig
+}
+
+// defaultConfig suits development against the database of the docker setup.
+func defaultConfig() apiConfig {
+	return apiConfig{
+		Common:    config.Common{Env: config.Development},
+		Port:      8090,
+		Domain:    "example.com",
+		JWTSecret: "secretString",
+		Database: config.Database{
+			DSN:     config.DefaultDSN,
+			Timeout: dbrepo.DefaultTimeout,
+			Migrate: true,
+		},
+		Passwords: config.Passwords{Hash: "bcrypt", BcryptCost: data.DefaultBcryptCost},
+		OIDC:      config.OIDC{RedirectURL: "http://localhost:8090/v1/auth/oidc/callback"},
+		LegacySunset: "2027-04-19",
+		CORS:         defaultCORS(),
+	}
+}
+
+// Validate checks the settings config.Load cannot.
+func (c *apiConfig) Validate() error {
+	if _, err := c.sunset(); err != nil {
+		return fmt.Errorf("invalid legacy-sunset: %w", err)
+	}
+	return nil
+}
+
+func (c *apiConfig) sunset() (time.Time, error) {
+	return time.Parse("2006-01-02", c.LegacySunset)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"webApp/pkg/config"
+)
+
+func Test_defaultConfig(t *testing.T) {
+	var tests = []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"development", nil, ""},
+		{"production defaults", []string{"-env", "production"}, "jwt-secret must not be left"},
+		{"production", []string{"-env", "production", "-jwt-secret", "0123456789abcdef", "-dsn", "sqlite:users.db"}, ""},
+		{"bad sunset", []string{"-legacy-sunset", "someday"}, "legacy-sunset"},
+		{"bad cors", []string{"-cors-origins", "*"}, "credentials"},
+	}
+
+	for _, e := range tests {
+		fs := flag.NewFlagSet("api", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+
+		cfg := defaultConfig()
+		err := config.Load(fs, &cfg, e.args)
+
+		switch {
+		case e.expected == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		case e.expected != "" && (err == nil || !strings.Contains(err.Error(), e.expected)):
+			t.Errorf("%s: expected an error about %q, but got %v", e.name, e.expected, err)
+		}
+	}
+}
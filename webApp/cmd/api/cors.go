@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig is the policy enableCORS applies to requests from other origins.
+type CORSConfig struct {
+	// AllowedOrigins are origins like https://app.example.com, or patterns
+	// with one *, like https://*.example.com; * alone allows any origin
+	AllowedOrigins []string `config:"cors-origins" usage:"comma separated origins allowed to call the API, e.g. https://*.example.com; * allows any"`
+	AllowedMethods []string `config:"cors-methods" usage:"comma separated methods allowed from other origins"`
+	AllowedHeaders []string `config:"cors-headers" usage:"comma separated request headers allowed from other origins"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `config:"cors-expose-headers" usage:"comma separated response headers other origins may read"`
+	// AllowCredentials lets browsers send cookies and read the responses
+	AllowCredentials bool `config:"cors-credentials" usage:"allow other origins to send cookies"`
+	// MaxAge is how long browsers may cache a preflight; 0 leaves it to them
+	MaxAge time.Duration `config:"cors-max-age" usage:"how long browsers may cache a preflight"`
+}
+
+// defaultCORS allows the single page app served with the API, and nothing else.
+func defaultCORS() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: []string{"http://localhost:8090"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Content-Type", "X-CSRF-Token", "Authorization", "X-API-Key", "If-Match", "X-Request-Id"},
+		ExposedHeaders: []string{"ETag", "X-Request-Id", "Deprecation", "Sunset", "Link"},
+		AllowCredentials: true,
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// Validate reports a policy that cannot work, or must not.
+func (c *CORSConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("cors origin %q has more than one *", origin)
+		}
+		// any site could act with the user's cookies
+		if origin == "*" && c.AllowCredentials {
+			return errors.New("cors origin * cannot be allowed with credentials")
+		}
+	}
+
+	if c.MaxAge < 0 {
+		return errors.New("cors max age must not be negative")
+	}
+
+	return nil
+}
+
+// allowsOrigin reports whether origin matches one of AllowedOrigins
+func (c *CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		prefix, suffix, ok := strings.Cut(strings.ToLower(allowed), "*")
+		if !ok {
+			continue
+		}
+
+		// the * stands for at least one character, and not for a path or
+		// port, so https://*.example.com does not allow https://evil.com/.example.com
+		lower := strings.ToLower(origin)
+		if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) {
+			wildcard := lower[len(prefix) : len(lower)-len(suffix)]
+			if !strings.ContainsAny(wildcard, "/:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// enableCORS applies app.CORS. Requests from allowed origins get the origin
+// back in Access-Control-Allow-Origin, and preflights are answered here;
+// preflights from other origins are refused, and their other requests get no
+// CORS headers, so browsers keep the responses from them.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the response depends on the origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !app.CORS.allowsOrigin(origin) {
+			if preflight {
+				app.errorJSON(w, r, errors.New("origin not allowed"), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if app.CORS.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(app.CORS.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(app.CORS.AllowedHeaders, ", "))
+			if app.CORS.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.CORS.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if len(app.CORS.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(app.CORS.ExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,36 +1,41 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
-	//sqlがdriverを探すときに使う
-	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"webApp/pkg/repository/dbrepo"
+	"webApp/pkg/repository/migrations"
 )
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+// connectToDB opens the database app.DSN names: Postgres, or SQLite for a DSN
+// starting with sqlite:. It returns the connection and its driver name.
+func (app *application) connectToDB() (*sql.DB, string, error) {
+	connection, driver, err := dbrepo.Open(app.DSN)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	//ping()→dbと通信できているかのテストをしている
-	err = db.Ping()
-	if err != nil {
-		return nil, err
+	if driver == dbrepo.DriverSQLite {
+		log.Println("Connected to SQLite!")
+	} else {
+		log.Println("Connected to Postgres!")
 	}
 
-	return db, nil
+	return connection, driver, nil
 }
 
-func (app *application) connectToDB() (*sql.DB, error) {
-	connection, err := openDB(app.DSN)
+// migrate brings the database schema up to date
+func (app *application) migrate(conn *sql.DB, driver string) error {
+	migrator, err := migrations.New(conn, driver)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	log.Println("Connected to Postgres!")
+	applied, err := migrator.Up(context.Background())
+	for _, m := range applied {
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+	}
 
-	return connection, nil
-}
\ No newline at end of file
+	return err
+}
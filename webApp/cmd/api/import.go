@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"webApp/pkg/data"
+	"webApp/pkg/export"
+	"webApp/pkg/repository"
+	"webApp/pkg/validator"
+)
+
+const (
+	// maxImportBytes and maxImportRows bound the size of one import. Every
+	// row's password is hashed while the request waits, a quarter of a second
+	// of CPU at bcrypt's default cost, so the rows are kept to what hashes in
+	// seconds on a few cores.
+	maxImportBytes = 10 * 1024 * 1024
+	maxImportRows  = 500
+)
+
+// importBatchSize is how many users are inserted per transaction
+var importBatchSize = 50
+
+// The outcomes of an imported row.
+const (
+	importCreated = "created"
+	importSkipped = "skipped"
+	importFailed  = "failed"
+)
+
+// importColumns are the columns an imported CSV file may have; all but
+// is_admin are required.
+var importColumns = []string{"first_name", "last_name", "email", "password", "is_admin"}
+
+// ImportRow reports the outcome of one imported row. Row counts from 1, and
+// does not count the header of a CSV file.
+type ImportRow struct {
+	Row    int              `json:"row"`
+	Email  string           `json:"email"`
+	Status string           `json:"status"`
+	ID     int              `json:"id,omitempty"`
+	Reason string           `json:"reason,omitempty"`
+	Errors validator.Errors `json:"errors,omitempty"`
+}
+
+// ImportReport is the answer to an import. In a dry run nothing is stored, and
+// the rows reported as created are the ones that would be.
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// importUsers creates users from a JSON array of NewUser, or a CSV file with
+// the same fields, and reports what became of every row. Invalid rows fail,
+// rows whose email is taken, or repeats an earlier row, are skipped, and the
+// rest is inserted in batches of importBatchSize, each in one transaction.
+// With ?dry_run=true, nothing is inserted. Admin only.
+func (app *application) importUsers(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("dry_run must be true or false"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, parseErrors, status, err := app.readImport(w, r)
+	if err != nil {
+		app.errorJSON(w, r, err, status)
+		return
+	}
+
+	report := ImportReport{DryRun: dryRun, Rows: make([]ImportRow, len(users))}
+	var pending []int
+	seen := map[string]bool{}
+
+	for i, user := range users {
+		row := ImportRow{Row: i + 1, Email: user.Email}
+
+		v := validator.New()
+		for field, messages := range parseErrors[i] {
+			v.Errors[field] = messages
+		}
+		user.Validate(v)
+
+		switch {
+		case !v.Valid():
+			row.Status, row.Reason, row.Errors = importFailed, v.Errors.Error(), v.Errors
+		case seen[user.Email]:
+			row.Status, row.Reason = importSkipped, "repeats an earlier row"
+		default:
+			seen[user.Email] = true
+
+			_, err := app.DB.GetUserByEmail(r.Context(), user.Email)
+			switch {
+			case err == nil:
+				row.Status, row.Reason = importSkipped, repository.ErrDuplicateEmail.Error()
+			case errors.Is(err, repository.ErrNotFound):
+				row.Status = importCreated
+				pending = append(pending, i)
+			default:
+				app.dbErrorJSON(w, r, err)
+				return
+			}
+		}
+
+		report.Rows[i] = row
+	}
+
+	if !dryRun {
+		// hashed up front, so that no transaction is held open for it
+		hashed, err := app.hashImportPasswords(r.Context(), users, pending)
+		if err != nil {
+			log.Println("import failed:", err)
+			for _, i := range pending {
+				report.Rows[i].Status, report.Rows[i].Reason = importFailed, "could not be stored"
+			}
+			pending = nil
+		}
+
+		for start := 0; start < len(pending); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			app.insertImportBatch(r, hashed, report.Rows, pending[start:end])
+		}
+	}
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case importCreated:
+			report.Created++
+		case importSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, report)
+}
+
+// hashImportPasswords returns the users at the indexes in pending, by index,
+// with their passwords hashed. Hashing is slow on purpose, so it is spread
+// over as many goroutines as there are CPUs to run them.
+func (app *application) hashImportPasswords(ctx context.Context, users []NewUser, pending []int) (map[int]data.User, error) {
+	hashed := make([]data.User, len(pending))
+	errs := make([]error, len(pending))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				user := users[pending[n]].user()
+				user.Password, errs[n] = app.passwordHasher().Hash(user.Password)
+				hashed[n] = user
+			}
+		}()
+	}
+
+	var err error
+	for n := range pending {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	byIndex := make(map[int]data.User, len(pending))
+	for n, i := range pending {
+		if errs[n] != nil {
+			return nil, errs[n]
+		}
+		byIndex[i] = hashed[n]
+	}
+
+	return byIndex, nil
+}
+
+// insertImportBatch inserts the users at the indexes in batch in one
+// transaction, and records the outcome in rows. hashed holds the users, their
+// passwords already hashed, so that the transaction is only held open for the
+// inserts. A user whose email was taken since it was checked is skipped, and
+// the batch tried again without it; any other error fails the whole batch.
+func (app *application) insertImportBatch(r *http.Request, hashed map[int]data.User, rows []ImportRow, batch []int) {
+	for len(batch) > 0 {
+		var ids []int
+		failed := -1
+
+		err := app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+			ids = ids[:0]
+			for n, i := range batch {
+				id, err := repo.InsertHashedUser(r.Context(), hashed[i])
+				if err != nil {
+					failed = n
+					return err
+				}
+				ids = append(ids, id)
+			}
+			return nil
+		})
+
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			rows[batch[failed]].Status, rows[batch[failed]].Reason = importSkipped, err.Error()
+
+			rest := make([]int, 0, len(batch)-1)
+			rest = append(rest, batch[:failed]...)
+			batch = append(rest, batch[failed+1:]...)
+			continue
+		}
+
+		if err != nil {
+			log.Println("import batch failed:", err)
+			for _, i := range batch {
+				rows[i].Status, rows[i].Reason = importFailed, "could not be stored"
+			}
+			return
+		}
+
+		actorID, _ := userIDFromContext(r)
+		for n, i := range batch {
+			rows[i].ID = ids[n]
+			app.audit(r, data.AuditEvent{ActorID: actorID, Action: data.AuditUserCreated, TargetUserID: ids[n], Details: map[string]any{"email": hashed[i].Email, "is_admin": hashed[i].IsAdmin, "import": true}})
+		}
+		return
+	}
+}
+
+// readImport reads the users to import from a JSON array or a CSV file,
+// depending on the request's content type. Values a CSV file has in the wrong
+// form are reported by row, as parse errors, rather than failing the import;
+// a body that cannot be read at all is an error, with the status to answer.
+func (app *application) readImport(w http.ResponseWriter, r *http.Request) ([]NewUser, map[int]validator.Errors, int, error) {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, nil, http.StatusUnsupportedMediaType, err
+		}
+	}
+
+	var users []NewUser
+	parseErrors := map[int]validator.Errors{}
+
+	switch mediaType {
+	case "application/json":
+		if err := app.readJSONLimit(w, r, &users, maxImportBytes); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+	case export.CSV:
+		var err error
+		users, parseErrors, err = readImportCSV(http.MaxBytesReader(w, r.Body, maxImportBytes))
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+	default:
+		return nil, nil, http.StatusUnsupportedMediaType, errors.New("content type must be application/json or " + export.CSV)
+	}
+
+	if len(users) > maxImportRows {
+		return nil, nil, http.StatusRequestEntityTooLarge, fmt.Errorf("at most %d users can be imported at once", maxImportRows)
+	}
+
+	return users, parseErrors, 0, nil
+}
+
+// readImportCSV reads users from a CSV file whose first line names its
+// columns, in any order.
+func readImportCSV(body io.Reader) ([]NewUser, map[int]validator.Errors, error) {
+	reader := csv.NewReader(body)
+	// a row with too few or too many fields fails on its own, not the import
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("csv file has no header")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheets like to start their files with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !contains(importColumns, name) {
+			return nil, nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range importColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var users []NewUser
+	parseErrors := map[int]validator.Errors{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the fields a short row lacks read as blank
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		user := NewUser{
+			FirstName: field("first_name"),
+			LastName: field("last_name"),
+			Email: field("email"),
+			Password: field("password"),
+		}
+
+		if len(record) != len(header) {
+			parseErrors[len(users)] = validator.Errors{"row": {fmt.Sprintf("has %d fields, but the header has %d", len(record), len(header))}}
+		} else if value := field("is_admin"); value != "" {
+			user.IsAdmin, err = strconv.Atoi(value)
+			if err != nil {
+				parseErrors[len(users)] = validator.Errors{"is_admin": {"must be 0 or 1"}}
+			}
+		}
+
+		users = append(users, user)
+		if len(users) > maxImportRows {
+			break
+		}
+	}
+
+	return users, parseErrors, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_importUsers(t *testing.T) {
+	jsonRows := `[
+		{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"secret"},
+		{"first_name":"","last_name":"Smith","email":"jill","password":"secret"},
+		{"first_name":"Admin","last_name":"User","email":"admin@example.com","password":"secret"},
+		{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"other"}
+	]`
+	csvRows := "\ufeffemail,first_name,last_name,password,is_admin\n" +
+		"jack@example.com,Jack,Smith,secret,1\n" +
+		"jill@example.com,Jill,Smith,secret,yes\n" +
+		"admin@example.com,Admin,User,secret,\n"
+
+	var tests = []struct {
+		name            string
+		contentType     string
+		body            string
+		query           string
+		userID          int
+		expectedStatus  int
+		expectedCreated int
+		expectedSkipped int
+		expectedFailed  int
+		expectedUsers   int
+	}{
+		{"json", "application/json", jsonRows, "", 1, http.StatusOK, 1, 2, 1, 3},
+		{"json dry run", "application/json", jsonRows, "?dry_run=true", 1, http.StatusOK, 1, 2, 1, 2},
+		{"no content type", "", jsonRows, "", 1, http.StatusOK, 1, 2, 1, 3},
+		{"csv", "text/csv; charset=utf-8", csvRows, "", 1, http.StatusOK, 1, 1, 1, 3},
+		{"csv dry run", "text/csv", csvRows, "?dry_run=1", 1, http.StatusOK, 1, 1, 1, 2},
+		{"empty", "application/json", `[]`, "", 1, http.StatusOK, 0, 0, 0, 2},
+		{"csv unknown column", "text/csv", "email,nickname\n", "", 1, http.StatusBadRequest, 0, 0, 0, 2},
+		{"csv missing column", "text/csv", "email,first_name,last_name\n", "", 1, http.StatusBadRequest, 0, 0, 0, 2},
+		{"csv ragged rows", "text/csv", "email,first_name,last_name,password\na@example.com,A\nb@example.com,B,Smith,secret\nc@example.com,C,Smith,secret,1\n", "", 1, http.StatusOK, 1, 0, 2, 3},
+		{"json unknown field", "application/json", `[{"nickname":"jack"}]`, "", 1, http.StatusBadRequest, 0, 0, 0, 2},
+		{"not an array", "application/json", `{"email":"jack@example.com"}`, "", 1, http.StatusBadRequest, 0, 0, 0, 2},
+		{"bad dry_run", "application/json", jsonRows, "?dry_run=maybe", 1, http.StatusBadRequest, 0, 0, 0, 2},
+		{"unsupported type", "application/xml", "<users/>", "", 1, http.StatusUnsupportedMediaType, 0, 0, 0, 2},
+		{"not an admin", "application/json", jsonRows, "", 2, http.StatusForbidden, 0, 0, 0, 2},
+	}
+
+	for _, e := range tests {
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req, _ := http.NewRequest("POST", "/users/import"+e.query, strings.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), contextUserIDKey, e.userID))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.importUsers)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+			continue
+		}
+
+		users, _ := app.DB.AllUsers(context.Background())
+		if len(users) != e.expectedUsers {
+			t.Errorf("%s: expected %d users afterwards but got %d", e.name, e.expectedUsers, len(users))
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var report ImportReport
+		_ = json.Unmarshal(rr.Body.Bytes(), &report)
+
+		if report.Created != e.expectedCreated || report.Skipped != e.expectedSkipped || report.Failed != e.expectedFailed {
+			t.Errorf("%s: expected %d created, %d skipped and %d failed but got %+v", e.name, e.expectedCreated, e.expectedSkipped, e.expectedFailed, report)
+		}
+
+		if report.DryRun != strings.Contains(e.query, "dry_run") {
+			t.Errorf("%s: wrong dry_run %t", e.name, report.DryRun)
+		}
+
+		for _, row := range report.Rows {
+			if row.Status == importCreated && (row.ID != 0) == report.DryRun {
+				t.Errorf("%s: row %d has id %d in a dry run of %t", e.name, row.Row, row.ID, report.DryRun)
+			}
+			if row.Status == importFailed && len(row.Errors) == 0 {
+				t.Errorf("%s: row %d failed without errors", e.name, row.Row)
+			}
+		}
+	}
+
+	app.DB = dbrepo.NewTestDBRepo()
+}
+
+// emailBlindDB does not see the users that exist, as if they were inserted
+// after the import checked for them
+type emailBlindDB struct {
+	*dbrepo.MemoryDBRepo
+}
+
+func (m *emailBlindDB) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return nil, repository.ErrNotFound
+}
+
+func Test_app_importUsersBatches(t *testing.T) {
+	oldBatchSize := importBatchSize
+	importBatchSize = 2
+	defer func() { importBatchSize = oldBatchSize }()
+
+	app.DB = &emailBlindDB{MemoryDBRepo: dbrepo.NewTestDBRepo()}
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	body := "email,first_name,last_name,password\n" +
+		"a@example.com,A,Smith,secret\n" +
+		"admin@example.com,Admin,User,secret\n" +
+		"b@example.com,B,Smith,secret\n" +
+		"c@example.com,C,Smith,secret\n" +
+		"d@example.com,D,Smith,secret\n"
+
+	req, _ := http.NewRequest("POST", "/users/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req = req.WithContext(context.WithValue(req.Context(), contextUserIDKey, 1))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.importUsers).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var report ImportReport
+	_ = json.Unmarshal(rr.Body.Bytes(), &report)
+
+	// the taken email only shows when its batch is inserted; the rest of
+	// that batch must still be
+	if report.Created != 4 || report.Skipped != 1 || report.Rows[1].Status != importSkipped {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	users, _ := app.DB.AllUsers(context.Background())
+	if len(users) != 6 {
+		t.Errorf("expected 6 users but got %d", len(users))
+	}
+
+	for _, row := range report.Rows {
+		if row.Status != importCreated {
+			continue
+		}
+		u, err := app.DB.GetUser(context.Background(), row.ID)
+		if err != nil || u.Email != row.Email {
+			t.Errorf("row %d: user %d is not %s", row.Row, row.ID, row.Email)
+			continue
+		}
+
+		// hashed before the transaction, once
+		if ok, _ := u.PasswordMatches("secret"); !ok {
+			t.Errorf("row %d: password not stored as its hash", row.Row)
+		}
+	}
+}
+
+func Test_app_importUsersTooMany(t *testing.T) {
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	jsonRows := "[" + strings.Repeat(`{"email":"jack@example.com"},`, maxImportRows) + `{"email":"jack@example.com"}]`
+	csvRows := "email,first_name,last_name,password\n" + strings.Repeat("jack@example.com,Jack,Smith,secret\n", maxImportRows+1)
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json", "application/json", jsonRows},
+		{"csv", "text/csv", csvRows},
+	}
+
+	for _, e := range tests {
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req, _ := http.NewRequest("POST", "/users/import", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+		req = req.WithContext(context.WithValue(req.Context(), contextUserIDKey, 1))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.importUsers).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected status %d for %d rows but got %d", e.name, http.StatusRequestEntityTooLarge, maxImportRows+1, rr.Code)
+		}
+	}
+}
@@ -1,41 +1,95 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	"webApp/pkg/clientip"
+	"webApp/pkg/config"
+	"webApp/pkg/data"
+	"webApp/pkg/oidc"
 	"webApp/pkg/repository"
 	"webApp/pkg/repository/dbrepo"
 )
 
-const port = 8090
-
 type application struct {
 	DSN       string
 	DB        repository.DatabaseRepo
 	Domain    string
 	JWTSecret string
+	Hasher    data.PasswordHasher
+	OIDC      *oidc.Client
+	// ClientIP finds the address of a client behind the trusted proxies
+	ClientIP *clientip.Resolver
+	// LegacySunset is when the routes from before versioning stop working
+	LegacySunset time.Time
+	CORS         CORSConfig
 }
 
 func main() {
-	var app application
-	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "secretString", "signing secret")
-	flag.Parse()
+	cfg := defaultConfig()
+	err := config.Load(flag.CommandLine, &cfg, os.Args[1:])
+	if err == config.ErrPrinted {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Configuration:\n%s", config.String(&cfg))
 
-	conn, err := app.connectToDB()
+	app := application{
+		DSN:       cfg.Database.DSN,
+		Domain:    cfg.Domain,
+		JWTSecret: cfg.JWTSecret,
+		CORS:      cfg.CORS,
+	}
+	app.LegacySunset, _ = cfg.sunset()
+
+	hasher, err := data.NewPasswordHasher(cfg.Passwords.Hash, cfg.Passwords.BcryptCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Hasher = hasher
+
+	app.ClientIP, err = clientip.New(cfg.Proxies.Trusted)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.OIDC.IssuerURL != "" {
+		app.OIDC, err = oidc.NewClient(context.Background(), oidc.Config{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	conn, driver, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if cfg.Database.Migrate {
+		err = app.migrate(conn, driver)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.DB = dbrepo.New(conn, driver, app.Hasher, cfg.Database.Timeout)
 
-	log.Printf("Starting api on port, %d", port)
+	log.Printf("Starting api on port, %d", cfg.Port)
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	err = http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), app.routes())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"webApp/pkg/data"
+)
+
+// mergePatchType is the media type of RFC 7386 JSON merge patch documents
+const mergePatchType = "application/merge-patch+json"
+
+// isMergePatch reports whether the request body can be read as a merge patch.
+// Clients that send plain JSON, or no content type at all, are let through.
+func isMergePatch(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == mergePatchType || mediaType == "application/json"
+}
+
+// applyUserPatch applies a merge patch to user: every field in the patch
+// replaces the user's, and null removes it, leaving it empty. Only the fields a
+// client can set may be patched; whether the result is valid is up to
+// validateUser.
+func applyUserPatch(user *data.User, patch map[string]json.RawMessage) error {
+	// in order, so that the same patch always fails the same way
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		value := patch[field]
+
+		var err error
+		switch field {
+		case "first_name":
+			err = unmarshalPatchValue(value, &user.FirstName)
+		case "last_name":
+			err = unmarshalPatchValue(value, &user.LastName)
+		case "email":
+			err = unmarshalPatchValue(value, &user.Email)
+		case "is_admin":
+			err = unmarshalPatchValue(value, &user.IsAdmin)
+		case "id":
+			var id int
+			err = unmarshalPatchValue(value, &id)
+			if err == nil && id != user.ID {
+				err = errors.New("can not be changed")
+			}
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	return nil
+}
+
+// unmarshalPatchValue decodes one value of a merge patch into the field v
+// points to; null sets the field to its zero value.
+func unmarshalPatchValue[T any](value json.RawMessage, v *T) error {
+	if string(value) == "null" {
+		var zero T
+		*v = zero
+		return nil
+	}
+
+	return json.Unmarshal(value, v)
+}
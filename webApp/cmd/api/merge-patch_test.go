@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"webApp/pkg/data"
+)
+
+func Test_applyUserPatch(t *testing.T) {
+	var tests = []struct {
+		name          string
+		patch         string
+		expectedUser  data.User
+		expectedError string
+	}{
+		{"one field", `{"first_name":"Jane"}`, data.User{ID: 1, FirstName: "Jane", LastName: "User", Email: "admin@example.com", IsAdmin: 1}, ""},
+		{"every field", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","is_admin":0}`, data.User{ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"}, ""},
+		{"nothing", `{}`, data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1}, ""},
+		{"null", `{"last_name":null,"is_admin":null}`, data.User{ID: 1, FirstName: "Admin", Email: "admin@example.com"}, ""},
+		{"wrong type", `{"first_name":1}`, data.User{}, "first_name: json: cannot unmarshal number into Go value of type string"},
+		{"other id", `{"id":2}`, data.User{}, "id: can not be changed"},
+		{"unknown field", `{"password":"secret"}`, data.User{}, `unknown field "password"`},
+		{"first error in order", `{"last_name":1,"first_name":1,"id":2}`, data.User{}, "first_name: json: cannot unmarshal number into Go value of type string"},
+	}
+
+	for _, e := range tests {
+		user := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1}
+
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(e.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+
+		err := applyUserPatch(&user, patch)
+		if e.expectedError != "" {
+			if err == nil || err.Error() != e.expectedError {
+				t.Errorf("%s: expected error %q, but got %v", e.name, e.expectedError, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+		}
+		if user != e.expectedUser {
+			t.Errorf("%s: expected %+v, but got %+v", e.name, e.expectedUser, user)
+		}
+	}
+}
+
+func Test_isMergePatch(t *testing.T) {
+	var tests = []struct {
+		contentType string
+		expected    bool
+	}{
+		{"application/merge-patch+json", true},
+		{"application/merge-patch+json; charset=utf-8", true},
+		{"application/json", true},
+		{"", true},
+		{"text/plain", false},
+		{"application/json-patch+json", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/", nil)
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+
+		if isMergePatch(req) != e.expected {
+			t.Errorf("%q: expected %t", e.contentType, e.expected)
+		}
+	}
+}
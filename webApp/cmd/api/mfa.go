@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+	"webApp/pkg/repository"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// MFAChallenge is sent instead of a token pair when the user has two-factor
+// authentication enabled.
+type MFAChallenge struct {
+	Required bool   `json:"mfa_required"`
+	Token    string `json:"mfa_token"`
+}
+
+// MFACredentials is the payload for the second login step.
+type MFACredentials struct {
+	Token string `json:"mfa_token"`
+	Code  string `json:"code"`
+}
+
+// mfaEnabled reports whether the user has confirmed two-factor authentication.
+// Errors other than "no settings stored" are returned, so that callers fail closed.
+func (app *application) mfaEnabled(ctx context.Context, userID int) (bool, error) {
+	userMFA, err := app.DB.GetUserMFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return userMFA.Enabled, nil
+}
+
+// authenticateMFA is the second login step: it exchanges a challenge token and
+// a valid code for a token pair.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var creds MFACredentials
+
+	err := app.readJSON(w, r, &creds)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(creds.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(app.JWTSecret), nil
+	})
+	if err != nil || claims.Purpose != purposeMFA || claims.Issuer != app.Domain {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// counted per user, as the challenge token can be used until it expires
+	err = mfa.Verify(r.Context(), app.DB, userID, creds.Code, time.Now())
+	if errors.Is(err, mfa.ErrLocked) {
+		app.errorJSON(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	tokenPairs, err := app.generateTokenPair(user)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID, Details: map[string]any{"method": "mfa"}})
+
+	http.SetCookie(w, &http.Cookie{
+		Name: "Host-refresh_token",
+		Path: "/",
+		Value: tokenPairs.RefreshToken,
+		Expires: time.Now().Add(refreshTokenExpiry),
+		MaxAge: int(refreshTokenExpiry.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain: "localhost",
+		HttpOnly: true,
+		Secure: true,
+	})
+
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_authenticateMFA(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	mfaUser := data.User{ID: 2, FirstName: "MFA", LastName: "User", Email: "mfa@example.com"}
+
+	mfaToken, _ := app.generateMFAToken(&mfaUser)
+	accessTokens, _ := app.generateTokenPair(&mfaUser)
+	code, _ := mfa.Code(dbrepo.TestMFASecret, time.Now())
+
+	var tests = []struct {
+		name               string
+		token              string
+		code               string
+		expectedStatusCode int
+	}{
+		{"valid code", mfaToken, code, http.StatusOK},
+		{"replayed code", mfaToken, code, http.StatusUnauthorized},
+		{"recovery code", mfaToken, dbrepo.TestRecoveryCode, http.StatusOK},
+		{"wrong code", mfaToken, "000000", http.StatusUnauthorized},
+		{"access token instead of challenge", accessTokens.Token, code, http.StatusUnauthorized},
+		{"refresh token instead of challenge", accessTokens.RefreshToken, code, http.StatusUnauthorized},
+		{"no token", "", code, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		body := fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, e.token, e.code)
+		req, _ := http.NewRequest("POST", "/auth/mfa", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.authenticateMFA)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// wrong codes count against the user, however many challenges they get
+	app.DB = dbrepo.NewTestDBRepo()
+	for i := 0; i <= mfa.MaxAttempts; i++ {
+		mfaToken, _ = app.generateMFAToken(&mfaUser)
+		code, expected := "000000", http.StatusUnauthorized
+		if i == mfa.MaxAttempts {
+			code, _ = mfa.Code(dbrepo.TestMFASecret, time.Now().Add(mfa.Period*time.Second))
+			expected = http.StatusTooManyRequests
+		}
+
+		body := fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, mfaToken, code)
+		req, _ := http.NewRequest("POST", "/auth/mfa", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticateMFA).ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("attempt %d: expected status %d but got %d", i+1, expected, rr.Code)
+		}
+	}
+}
+
+func Test_app_mfaTokenIsNotAccessOrRefreshToken(t *testing.T) {
+	mfaToken, _ := app.generateMFAToken(&data.User{ID: 2})
+
+	// challenge token as a bearer token
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+mfaToken)
+	_, _, err := app.getTokenFromHeaderandVerify(httptest.NewRecorder(), req)
+	if err == nil {
+		t.Error("mfa challenge token accepted as access token")
+	}
+
+	// challenge token as a refresh token, close to expiry
+	oldExpiry := mfaTokenExpiry
+	mfaTokenExpiry = time.Second
+	mfaToken, _ = app.generateMFAToken(&data.User{ID: 2})
+	mfaTokenExpiry = oldExpiry
+
+	postedData := url.Values{"refresh_token": {mfaToken}}
+	req, _ = http.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.refresh)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("mfa challenge token used as refresh token; expected %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var oidcFlowExpiry = time.Minute * 10
+
+// purposeOIDC marks the cookie that carries state, nonce and PKCE verifier
+// while the user is at the identity provider
+const purposeOIDC = "oidc"
+
+// oidcFlowClaims is what we keep, signed, in the Host-oidc_flow cookie
+type oidcFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Purpose  string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// loginOIDC sends the client to the identity provider to sign in.
+func (app *application) loginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, r, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	flow := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcFlowClaims{
+		State: state,
+		Nonce: nonce,
+		Verifier: verifier,
+		Purpose: purposeOIDC,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: app.Domain,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcFlowExpiry)),
+		},
+	})
+
+	signedFlow, err := flow.SignedString([]byte(app.JWTSecret))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Lax, not Strict: the cookie has to come along when the provider redirects back
+	http.SetCookie(w, &http.Cookie{
+		Name: "Host-oidc_flow",
+		Path: "/",
+		Value: signedFlow,
+		Expires: time.Now().Add(oidcFlowExpiry),
+		MaxAge: int(oidcFlowExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		Domain: "localhost",
+		HttpOnly: true,
+		Secure: true,
+	})
+
+	http.Redirect(w, r, app.OIDC.AuthCodeURL(state, nonce, challenge), http.StatusFound)
+}
+
+// oidcCallback finishes signing in once the identity provider sends the client
+// back, and responds like authenticate does.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, r, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie("Host-oidc_flow")
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// the flow cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name: "Host-oidc_flow",
+		Path: "/",
+		Value: "",
+		Expires: time.Unix(0, 0),
+		MaxAge: -1,
+		SameSite: http.SameSiteLaxMode,
+		Domain: "localhost",
+		HttpOnly: true,
+		Secure: true,
+	})
+
+	flow := &oidcFlowClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, flow, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(app.JWTSecret), nil
+	})
+	if err != nil || flow.Purpose != purposeOIDC || flow.Issuer != app.Domain {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("error") != "" || q.Get("state") != flow.State {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := app.OIDC.Exchange(r.Context(), q.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Println(err)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := oidc.ResolveUser(r.Context(), app.DB, app.OIDC.Issuer(), claims)
+	if err != nil {
+		log.Println(err)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// users with two-factor enabled get a challenge token instead of tokens
+	enabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if enabled {
+		mfaToken, err := app.generateMFAToken(user)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		_ = app.writeJSON(w, http.StatusAccepted, MFAChallenge{Required: true, Token: mfaToken})
+		return
+	}
+
+	tokenPairs, err := app.generateTokenPair(user)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID, Details: map[string]any{"method": "oidc"}})
+
+	http.SetCookie(w, &http.Cookie{
+		Name: "Host-refresh_token",
+		Path: "/",
+		Value: tokenPairs.RefreshToken,
+		Expires: time.Now().Add(refreshTokenExpiry),
+		MaxAge: int(refreshTokenExpiry.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain: "localhost",
+		HttpOnly: true,
+		Secure: true,
+	})
+
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
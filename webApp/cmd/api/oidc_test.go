@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webApp/pkg/oidc"
+	"webApp/pkg/oidc/oidctest"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_oidcLogin(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	client, err := oidc.NewClient(context.Background(), provider.Config("http://localhost:8090/auth/oidc/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.OIDC = client
+	defer func() { app.OIDC = nil }()
+
+	var tests = []struct {
+		name               string
+		email              string
+		verified           bool
+		sendCookie         bool
+		tamperState        bool
+		expectedStatusCode int
+	}{
+		{"valid login", "new@example.com", true, true, false, http.StatusOK},
+		{"no flow cookie", "new@example.com", true, false, false, http.StatusUnauthorized},
+		{"state mismatch", "new@example.com", true, true, true, http.StatusUnauthorized},
+		{"unverified email", "new@example.com", false, true, false, http.StatusUnauthorized},
+		{"admin email not linked", "admin@example.com", true, true, false, http.StatusUnauthorized},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		provider.User.Email = e.email
+		provider.User.EmailVerified = e.verified
+
+		// start the login, which sends us to the provider
+		req, _ := http.NewRequest("GET", "/auth/oidc", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.loginOIDC).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("%s: expected redirect to provider, got %d", e.name, rr.Code)
+		}
+
+		authURL, _ := rr.Result().Location()
+		callback, err := provider.Authorize(authURL.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.tamperState {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}
+
+		req2, _ := http.NewRequest("GET", callback.RequestURI(), nil)
+		if e.sendCookie {
+			for _, c := range rr.Result().Cookies() {
+				req2.AddCookie(c)
+			}
+		}
+		rr2 := httptest.NewRecorder()
+		http.HandlerFunc(app.oidcCallback).ServeHTTP(rr2, req2)
+
+		if rr2.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d but got %d", e.name, e.expectedStatusCode, rr2.Code)
+		}
+	}
+}
+
+func Test_app_oidcNotConfigured(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{app.loginOIDC, app.oidcCallback} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected %d but got %d", http.StatusNotFound, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes every route in routes(); the contract tests in
+// openapi_test.go check the handlers' responses against it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPI serves the OpenAPI document of the API
+func (app *application) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
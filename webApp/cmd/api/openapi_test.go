@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// openAPIDoc is the part of the OpenAPI document the contract tests read;
+// schemas stay raw, for gojsonschema
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components map[string]map[string]json.RawMessage `json:"components"`
+}
+
+type openAPIOperation struct {
+	RequestBody json.RawMessage            `json:"requestBody"`
+	Responses   map[string]json.RawMessage `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Headers map[string]json.RawMessage `json:"headers"`
+	Content map[string]struct {
+		Schema json.RawMessage `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIRequestBody struct {
+	Content map[string]struct {
+		Schema json.RawMessage `json:"schema"`
+	} `json:"content"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal("openapi.json is not valid JSON:", err)
+	}
+	return &doc
+}
+
+// resolve follows raw when it is a $ref to one of the components, into v
+func (doc *openAPIDoc) resolve(raw json.RawMessage, v any) error {
+	var ref struct {
+		Ref string `json:"$ref"`
+	}
+	_ = json.Unmarshal(raw, &ref)
+
+	if ref.Ref != "" {
+		parts := strings.Split(strings.TrimPrefix(ref.Ref, "#/components/"), "/")
+		if len(parts) != 2 || doc.Components[parts[0]][parts[1]] == nil {
+			return fmt.Errorf("unknown $ref %s", ref.Ref)
+		}
+		raw = doc.Components[parts[0]][parts[1]]
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// operation returns the operation documented for method on the path template
+// that matches path, e.g. /users/{userID} for /users/1
+func (doc *openAPIDoc) operation(method, path string) (*openAPIOperation, string, bool) {
+	// as in OpenAPI, a concrete path like /users/import wins over a template
+	// like /users/{userID}
+	template, ok := path, false
+	if _, ok = doc.Paths[path]; !ok {
+		for t := range doc.Paths {
+			if pathMatches(t, path) {
+				template, ok = t, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, "", false
+	}
+
+	raw, ok := doc.Paths[template][strings.ToLower(method)]
+	if !ok {
+		return nil, template, false
+	}
+
+	var op openAPIOperation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return nil, template, false
+	}
+	return &op, template, true
+}
+
+func pathMatches(template, path string) bool {
+	templateParts := strings.Split(template, "/")
+	pathParts := strings.Split(path, "/")
+	if len(templateParts) != len(pathParts) {
+		return false
+	}
+
+	for i, part := range templateParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return false
+			}
+			continue
+		}
+		if part != pathParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validate checks body against schema, which may $ref the document's components
+func (doc *openAPIDoc) validate(schema json.RawMessage, body []byte) error {
+	// the schema becomes a document of its own, that carries the components
+	// along for its $refs to point into
+	var root map[string]any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return err
+	}
+	root["components"] = doc.Components
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(root), gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid() {
+		var msgs []string
+		for _, e := range result.Errors() {
+			msgs = append(msgs, e.String())
+		}
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+// checkContract reports how the response rr, to a request of method to path
+// with body, differs from what the OpenAPI document promises
+func (doc *openAPIDoc) checkContract(method, path, contentType string, body []byte, rr *httptest.ResponseRecorder) []string {
+	op, template, ok := doc.operation(method, path)
+	if !ok {
+		return []string{fmt.Sprintf("%s %s is not documented", method, path)}
+	}
+
+	var problems []string
+
+	raw, ok := op.Responses[strconv.Itoa(rr.Code)]
+	if !ok {
+		raw, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []string{fmt.Sprintf("%s %s: status %d is not documented", method, template, rr.Code)}
+	}
+
+	var resp openAPIResponse
+	if err := doc.resolve(raw, &resp); err != nil {
+		return []string{err.Error()}
+	}
+
+	for name := range resp.Headers {
+		if rr.Header().Get(name) == "" {
+			problems = append(problems, fmt.Sprintf("%s %s: %d is documented with a %s header, but it was not sent", method, template, rr.Code, name))
+		}
+	}
+
+	if len(resp.Content) == 0 {
+		if rr.Body.Len() != 0 {
+			problems = append(problems, fmt.Sprintf("%s %s: %d is documented without a body, but got %s", method, template, rr.Code, rr.Body.String()))
+		}
+	} else {
+		mediaType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+		media, ok := resp.Content[mediaType]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %s: %d is not documented as %q", method, template, rr.Code, mediaType))
+		} else if isJSON(mediaType) {
+			if err := doc.validate(media.Schema, rr.Body.Bytes()); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s: %d does not match the schema: %s", method, template, rr.Code, err))
+			}
+		}
+	}
+
+	// the payloads of requests that succeeded are examples of valid ones
+	if body != nil && rr.Code < 300 && op.RequestBody != nil {
+		var requestBody openAPIRequestBody
+		if err := doc.resolve(op.RequestBody, &requestBody); err != nil {
+			return append(problems, err.Error())
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		media, ok := requestBody.Content[mediaType]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %s: request body is not documented as %q", method, template, mediaType))
+		} else if isJSON(mediaType) {
+			if err := doc.validate(media.Schema, body); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s: request body does not match the schema: %s", method, template, err))
+			}
+		}
+	}
+
+	return problems
+}
+
+// isJSON reports whether a body of mediaType is one JSON value
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func Test_app_openAPI(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/v1/openapi.json", nil)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, but got %d", rr.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document, but got version %q", doc.OpenAPI)
+	}
+}
+
+// Test_openAPIDocumentsRoutes checks that the document and routes() list the
+// same v1 operations, and that nothing else is registered but the same routes
+// from before versioning
+func Test_openAPIDocumentsRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	all := map[string]bool{}
+	_ = chi.Walk(app.routes().(chi.Routes), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// the SPA's static files
+		if route == "/" || route == "/*" {
+			return nil
+		}
+		all[method+" "+route] = true
+		return nil
+	})
+
+	// the document's paths are relative to its server, /v1
+	registered := map[string]bool{}
+	for op := range all {
+		method, route, _ := strings.Cut(op, " ")
+		if v1Route, ok := strings.CutPrefix(route, "/v1"); ok {
+			registered[method+" "+v1Route] = true
+		} else if !all[method+" /v1"+route] {
+			t.Errorf("%s is registered outside /v1, and is not a route from before versioning", op)
+		}
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var missing, extra []string
+	for op := range registered {
+		if !documented[op] {
+			missing = append(missing, op)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			extra = append(extra, op)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	for _, op := range missing {
+		t.Errorf("%s is registered, but not documented", op)
+	}
+	for _, op := range extra {
+		t.Errorf("%s is documented, but not registered", op)
+	}
+}
+
+// Test_openAPIContract sends requests through routes() and checks the
+// responses against the OpenAPI document
+func Test_openAPIContract(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+	mfaTokens, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "MFA", LastName: "User", Email: "mfa@example.com"})
+
+	var tests = []struct {
+		name               string
+		method             string
+		path               string
+		body               string
+		contentType        string
+		token              string
+		ifMatch            string
+		expectedStatusCode int
+		accept             string
+	}{
+		{"openapi", "GET", "/openapi.json", "", "", "", "", http.StatusOK, ""},
+		{"login", "POST", "/auth", `{"email":"admin@example.com","password":"secret"}`, "application/json", "", "", http.StatusOK, ""},
+		{"login with mfa", "POST", "/auth", `{"email":"mfa@example.com","password":"secret"}`, "application/json", "", "", http.StatusAccepted, ""},
+		{"failed login", "POST", "/auth", `{"email":"admin@example.com","password":"wrong"}`, "application/json", "", "", http.StatusUnauthorized, ""},
+		{"mfa bad token", "POST", "/auth/mfa", `{"mfa_token":"x","code":"123456"}`, "application/json", "", "", http.StatusUnauthorized, ""},
+		{"oidc not configured", "GET", "/auth/oidc", "", "", "", "", http.StatusNotFound, ""},
+		{"oidc callback not configured", "GET", "/auth/oidc/callback?code=x&state=y", "", "", "", "", http.StatusNotFound, ""},
+		{"refresh bad token", "POST", "/refresh-token", "refresh_token=x", "application/x-www-form-urlencoded", "", "", http.StatusBadRequest, ""},
+		{"spa login", "POST", "/web/auth", `{"email":"admin@example.com","password":"secret"}`, "application/json", "", "", http.StatusOK, ""},
+		{"spa mfa bad token", "POST", "/web/auth/mfa", `{"mfa_token":"x","code":"123456"}`, "application/json", "", "", http.StatusUnauthorized, ""},
+		{"spa refresh without cookie", "GET", "/web/refresh-token", "", "", "", "", http.StatusUnauthorized, ""},
+		{"spa logout", "GET", "/web/logout", "", "", "", "", http.StatusAccepted, ""},
+		{"all users", "GET", "/users/", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"all users as csv", "GET", "/users/", "", "", tokens.Token, "", http.StatusOK, "text/csv"},
+		{"all users as ndjson", "GET", "/users/", "", "", tokens.Token, "", http.StatusOK, "application/x-ndjson"},
+		{"all users not acceptable", "GET", "/users/", "", "", tokens.Token, "", http.StatusNotAcceptable, "text/html"},
+		{"all users unauthorized", "GET", "/users/", "", "", "", "", http.StatusUnauthorized, ""},
+		{"search users", "GET", "/users/search?q=adm", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"search users no match", "GET", "/users/search?q=nobody", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"search users bad limit", "GET", "/users/search?q=adm&limit=100", "", "", tokens.Token, "", http.StatusBadRequest, ""},
+		{"get user", "GET", "/users/1", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"get user bad id", "GET", "/users/x", "", "", tokens.Token, "", http.StatusBadRequest, ""},
+		{"get user not found", "GET", "/users/100", "", "", tokens.Token, "", http.StatusNotFound, ""},
+		{"insert user", "PUT", "/users/", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"secret"}`, "application/json", tokens.Token, "", http.StatusNoContent, ""},
+		{"insert user invalid", "PUT", "/users/", `{"first_name":"","last_name":"Smith","email":"jack","password":""}`, "application/json", tokens.Token, "", http.StatusUnprocessableEntity, ""},
+		{"insert user duplicate", "PUT", "/users/", `{"first_name":"Jack","last_name":"Smith","email":"admin@example.com","password":"secret"}`, "application/json", tokens.Token, "", http.StatusConflict, ""},
+		{"update user", "PATCH", "/users/", `{"id":2,"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":0}`, "application/json", tokens.Token, `"1"`, http.StatusNoContent, ""},
+		{"update user without if-match", "PATCH", "/users/", `{"id":2,"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":0}`, "application/json", tokens.Token, "", http.StatusPreconditionRequired, ""},
+		{"replace user", "PUT", "/users/2", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":0}`, "application/json", tokens.Token, `"1"`, http.StatusNoContent, ""},
+		{"replace user stale", "PUT", "/users/2", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":0}`, "application/json", tokens.Token, `"5"`, http.StatusPreconditionFailed, ""},
+		{"patch user", "PATCH", "/users/2", `{"first_name":"Jack","is_admin":null}`, mergePatchType, tokens.Token, `"1"`, http.StatusNoContent, ""},
+		{"patch user not a merge patch", "PATCH", "/users/2", `{"first_name":"Jack"}`, "text/plain", tokens.Token, `"1"`, http.StatusUnsupportedMediaType, ""},
+		{"import users", "POST", "/users/import", `[{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"secret"},{"first_name":"","email":"jill"},{"first_name":"Admin","last_name":"User","email":"admin@example.com","password":"secret"}]`, "application/json", tokens.Token, "", http.StatusOK, ""},
+		{"import users dry run", "POST", "/users/import?dry_run=true", `[{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"secret"}]`, "application/json", tokens.Token, "", http.StatusOK, ""},
+		{"import users csv", "POST", "/users/import", "email,first_name,last_name,password\njack@example.com,Jack,Smith,secret\n", "text/csv", tokens.Token, "", http.StatusOK, ""},
+		{"import users bad csv", "POST", "/users/import", "email,nickname\n", "text/csv", tokens.Token, "", http.StatusBadRequest, ""},
+		{"import users unsupported", "POST", "/users/import", "<users/>", "application/xml", tokens.Token, "", http.StatusUnsupportedMediaType, ""},
+		{"import users not admin", "POST", "/users/import", `[]`, "application/json", mfaTokens.Token, "", http.StatusForbidden, ""},
+		{"delete user", "DELETE", "/users/2", "", "", tokens.Token, "", http.StatusNoContent, ""},
+		{"restore user", "POST", "/users/3/restore", "", "", tokens.Token, "", http.StatusNoContent, ""},
+		{"restore user not admin", "POST", "/users/3/restore", "", "", mfaTokens.Token, "", http.StatusForbidden, ""},
+		{"reset password", "PUT", "/users/2/password", `{"password":"new secret"}`, "application/json", tokens.Token, "", http.StatusNoContent, ""},
+		{"all api keys", "GET", "/api-keys/", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"no api keys", "GET", "/api-keys/", "", "", mfaTokens.Token, "", http.StatusOK, ""},
+		{"insert api key", "POST", "/api-keys/", `{"name":"ci","scopes":["users:read"]}`, "application/json", tokens.Token, "", http.StatusCreated, ""},
+		{"insert api key invalid", "POST", "/api-keys/", `{"name":"ci","scopes":["everything"]}`, "application/json", tokens.Token, "", http.StatusUnprocessableEntity, ""},
+		{"revoke api key", "DELETE", "/api-keys/1", "", "", tokens.Token, "", http.StatusNoContent, ""},
+		{"audit events", "GET", "/audit/", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"no audit events", "GET", "/audit/?action=nothing", "", "", tokens.Token, "", http.StatusOK, ""},
+		{"audit events bad limit", "GET", "/audit/?limit=0", "", "", tokens.Token, "", http.StatusBadRequest, ""},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		var body io.Reader
+		if e.body != "" {
+			body = strings.NewReader(e.body)
+		}
+
+		req, _ := http.NewRequest(e.method, "/v1"+e.path, body)
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		if e.ifMatch != "" {
+			req.Header.Set("If-Match", e.ifMatch)
+		}
+		if e.accept != "" {
+			req.Header.Set("Accept", e.accept)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		var requestBody []byte
+		if e.body != "" {
+			requestBody = []byte(e.body)
+		}
+
+		path, _, _ := strings.Cut(e.path, "?")
+		for _, problem := range doc.checkContract(e.method, path, e.contentType, requestBody, rr) {
+			t.Errorf("%s: %s", e.name, problem)
+		}
+	}
+
+	app.DB = dbrepo.NewTestDBRepo()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"webApp/pkg/validator"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// Problem types that mean more than their status. Any other problem is
+// about:blank, whose title is the status text.
+const (
+	problemValidation      = "/problems/validation"
+	problemDuplicateEmail  = "/problems/duplicate-email"
+	problemVersionMismatch = "/problems/version-mismatch"
+)
+
+// Problem is an RFC 7807 problem details object, the body of every error the
+// API sends. Errors holds the messages for each invalid field of a payload, and
+// RequestID finds the request in the server's logs.
+type Problem struct {
+	Type      string           `json:"type"`
+	Title     string           `json:"title"`
+	Status    int              `json:"status"`
+	Detail    string           `json:"detail,omitempty"`
+	Instance  string           `json:"instance,omitempty"`
+	Errors    validator.Errors `json:"errors,omitempty"`
+	RequestID string           `json:"request_id,omitempty"`
+}
+
+// writeProblem sends p, filling in the type, title, instance and request id
+// when it leaves them out.
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	p.RequestID = middleware.GetReqID(r.Context())
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		log.Println("could not send problem:", err)
+		w.WriteHeader(p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(out)
+}
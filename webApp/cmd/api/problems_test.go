@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_app_errorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		status         int
+		expectedDetail string
+	}{
+		{"client error", errors.New("name is required"), http.StatusBadRequest, "name is required"},
+		{"server error", errors.New("dial tcp: connection refused"), http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		rr := httptest.NewRecorder()
+
+		app.errorJSON(rr, req, e.err, e.status)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.status, rr.Code)
+		}
+
+		if rr.Header().Get("Content-Type") != problemContentType {
+			t.Errorf("%s: expected content type %s, but got %s", e.name, problemContentType, rr.Header().Get("Content-Type"))
+		}
+
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Problem{Type: "about:blank", Title: http.StatusText(e.status), Status: e.status, Detail: e.expectedDetail, Instance: "/users/1"}
+		if p.Type != expected.Type || p.Title != expected.Title || p.Status != expected.Status || p.Detail != expected.Detail || p.Instance != expected.Instance {
+			t.Errorf("%s: expected %+v, but got %+v", e.name, expected, p)
+		}
+	}
+}
+
+func Test_app_problemRequestID(t *testing.T) {
+	var tests = []struct {
+		name       string
+		sentID     string
+		expectedID string
+	}{
+		{"client's id", "abc-123", "abc-123"},
+		{"generated id", "", ""},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		// no credentials, so the request ends in a problem
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		if e.sentID != "" {
+			req.Header.Set("X-Request-Id", e.sentID)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, but got %d", e.name, rr.Code)
+		}
+
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: expected a problem, but got %s", e.name, err)
+		}
+
+		header := rr.Header().Get("X-Request-Id")
+		if header == "" || p.RequestID != header {
+			t.Errorf("%s: expected the request id %q in the problem, but got %q", e.name, header, p.RequestID)
+		}
+
+		if e.expectedID != "" && header != e.expectedID {
+			t.Errorf("%s: expected request id %q, but got %q", e.name, e.expectedID, header)
+		}
+
+		if !strings.HasSuffix(p.Instance, "/users/") {
+			t.Errorf("%s: expected the request path as instance, but got %q", e.name, p.Instance)
+		}
+	}
+}
@@ -4,7 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"webApp/pkg/repository"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,26 +49,125 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
-	statusCode := http.StatusBadRequest
-	if len(status) > 0 {
-		statusCode = status[0]
+// errorJSON answers a request with a problem of status. For client errors,
+// err's message is the detail; server errors are logged, and the client is
+// told no more than the status.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s", middleware.GetReqID(r.Context()), err)
+		app.writeProblem(w, r, Problem{Status: status})
+		return
+	}
+
+	app.writeProblem(w, r, Problem{Status: status, Detail: err.Error()})
+}
+
+// dbErrorJSON sends an error from the repository with the status it calls for:
+// 404 when something does not exist, 409 when it clashes with existing data,
+// 412 when it changed since the client read it, and 500 when the database
+// failed. Only the repository's own errors are shown, never what the database
+// said.
+func (app *application) dbErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		app.writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: repository.ErrNotFound.Error()})
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		app.writeProblem(w, r, Problem{Type: problemDuplicateEmail, Title: "Duplicate email address", Status: http.StatusConflict, Detail: repository.ErrDuplicateEmail.Error()})
+	case errors.Is(err, repository.ErrConflict):
+		app.writeProblem(w, r, Problem{Status: http.StatusConflict, Detail: repository.ErrConflict.Error()})
+	case errors.Is(err, repository.ErrVersionMismatch):
+		app.writeProblem(w, r, Problem{Type: problemVersionMismatch, Title: "Version mismatch", Status: http.StatusPreconditionFailed, Detail: repository.ErrVersionMismatch.Error()})
+	default:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// userETag is the entity tag of one version of a user
+func userETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// versionFromIfMatch returns the user version an If-Match header asks for, or
+// 0 for *, which matches any version. Tags we did not hand out do not match
+// anything, and neither do weak ones, as If-Match compares strongly.
+func versionFromIfMatch(ifMatch string) (int, bool) {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "*" {
+		return 0, true
+	}
+
+	unquoted, ok := strings.CutPrefix(ifMatch, `"`)
+	if !ok {
+		return 0, false
+	}
+	unquoted, ok = strings.CutSuffix(unquoted, `"`)
+	if !ok {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// negotiate returns the one of offers the request's Accept header prefers, the
+// first when it has no Accept header, and "" when it accepts none of them.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
 	}
 
-	type jsonError struct {
-		Message string `json:"message"`
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		for _, offer := range offers {
+			if mediaRangeMatches(mediaRange, offer) {
+				best, bestQ = offer, q
+				break
+			}
+		}
 	}
 
-	theError := jsonError{
-		Message: err.Error(),
+	return best
+}
+
+// mediaRangeMatches reports whether a media range from an Accept header, such
+// as text/csv, text/* or */*, includes mediaType
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	prefix, ok := strings.CutSuffix(mediaRange, "*")
+	return ok && strings.HasPrefix(mediaType, prefix)
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	maxBytes := 1024 * 1024 // one megabyte
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return app.readJSONLimit(w, r, data, int64(maxBytes))
+}
+
+// readJSONLimit is readJSON for bodies of up to maxBytes
+func (app *application) readJSONLimit(w http.ResponseWriter, r *http.Request, data interface{}, maxBytes int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
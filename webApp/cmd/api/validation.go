@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"webApp/pkg/data"
+	"webApp/pkg/validator"
+)
+
+// maxPasswordLength is the longest password bcrypt hashes in full, in bytes
+// rather than characters: bcrypt ignores what comes after the 72nd byte
+const maxPasswordLength = 72
+
+// maxFieldLength is the width of the users table's varchar columns
+const maxFieldLength = 255
+
+// validatable is a payload that can check its own fields
+type validatable interface {
+	Validate(v *validator.Validator)
+}
+
+// readValidJSON reads a JSON payload like readJSON, and validates it. A payload
+// that can not be read is answered with 400, an invalid one with 422 and what
+// is wrong with each field; either way it returns false.
+func (app *application) readValidJSON(w http.ResponseWriter, r *http.Request, payload validatable) bool {
+	err := app.readJSON(w, r, payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return false
+	}
+
+	v := validator.New()
+	payload.Validate(v)
+	if !v.Valid() {
+		app.validationErrorJSON(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// validationErrorJSON sends 422 with the messages for every invalid field
+func (app *application) validationErrorJSON(w http.ResponseWriter, r *http.Request, errs validator.Errors) {
+	app.writeProblem(w, r, Problem{
+		Type: problemValidation,
+		Title: "Invalid request",
+		Status: http.StatusUnprocessableEntity,
+		Detail: errs.Error(),
+		Errors: errs,
+	})
+}
+
+// validateUser checks the fields of a user a client can set
+func validateUser(v *validator.Validator, u *data.User) {
+	v.Required("first_name", u.FirstName)
+	v.MaxLength("first_name", u.FirstName, maxFieldLength)
+	v.Required("last_name", u.LastName)
+	v.MaxLength("last_name", u.LastName, maxFieldLength)
+	v.Required("email", u.Email)
+	v.MaxLength("email", u.Email, maxFieldLength)
+	v.Email("email", u.Email)
+	v.Check(u.IsAdmin == 0 || u.IsAdmin == 1, "is_admin", "must be 0 or 1")
+}
+
+// validatePassword checks a new password
+func validatePassword(v *validator.Validator, field, password string) {
+	v.Required(field, password)
+	v.Check(len(password) <= maxPasswordLength, field, fmt.Sprintf("must be at most %d bytes", maxPasswordLength))
+}
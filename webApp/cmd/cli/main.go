@@ -5,24 +5,67 @@ import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"log"
+	"os"
 	"time"
+	"webApp/pkg/config"
 )
 
+// cliConfig are the settings of the cli, loaded by config.Load; the action
+// and its steps are flags only.
+type cliConfig struct {
+	config.Common
+	JWTSecret string `config:"jwt-secret" secret:"true" required:"true" usage:"secret"`
+	DSN       string `config:"dsn" secret:"dsn" usage:"database connection for the migrate actions: a Postgres DSN, or sqlite:<file> for SQLite"`
+}
+
 type application struct {
 	JWTSecret string
 	Action    string
+	DSN       string
+	Steps     int
 }
 
 // This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
 // the token that is printed out.
 // go run ./cmd/cli -action=valid     // will produce a valid token
 // go run ./cmd/cli -action=expired   // will produce an expired token
+//
+// It also manages the database schema:
+// go run ./cmd/cli -action=migrate-up              // applies every pending migration
+// go run ./cmd/cli -action=migrate-down -steps=1   // rolls back the last migration
+// go run ./cmd/cli -action=migrate-status          // lists migrations and when they were applied
+//
+// -jwt-secret and -dsn may also come from a -config file or WEBAPP_JWT_SECRET
+// and WEBAPP_DSN, as in the api and web commands.
 
 func main() {
 	var app application
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "secretString", "secret")
-	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
-	flag.Parse()
+	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired|migrate-up|migrate-down|migrate-status")
+	flag.IntVar(&app.Steps, "steps", 1, "number of migrations to roll back with migrate-down")
+
+	cfg := cliConfig{
+		Common:    config.Common{Env: config.Development},
+		JWTSecret: "secretString",
+		DSN:       config.DefaultDSN,
+	}
+	err := config.Load(flag.CommandLine, &cfg, os.Args[1:])
+	if err == config.ErrPrinted {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.JWTSecret = cfg.JWTSecret
+	app.DSN = cfg.DSN
+
+	switch app.Action {
+	case "migrate-up", "migrate-down", "migrate-status":
+		err := app.migrate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// generate a token
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"webApp/pkg/repository/dbrepo"
+	"webApp/pkg/repository/migrations"
+)
+
+// migrate runs one of the migrate-* actions against app.DSN
+func (app *application) migrate() error {
+	conn, driver, err := dbrepo.Open(app.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	migrator, err := migrations.New(conn, driver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch app.Action {
+	case "migrate-up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "migrate-down":
+		rolledBack, err := migrator.Down(ctx, app.Steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"webApp/pkg/data"
+)
+
+// audit records an event in the audit log, with the client's address as found
+// by addIPToContext. Failing to record an event is logged, but does not fail
+// the request.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	if ip, ok := r.Context().Value(contextUserKey).(string); ok {
+		e.IP = ip
+	}
+
+	if _, err := app.DB.InsertAuditEvent(r.Context(), e); err != nil {
+		log.Println("could not record audit event:", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"webApp/pkg/config"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+)
+
+// webConfig are the settings of the web app, loaded by config.Load.
+type webConfig struct {
+	config.Common
+	Port      int `config:"port" usage:"port to listen on"`
+	Database  config.Database
+	Passwords config.Passwords
+	OIDC      config.OIDC
+	Proxies   config.Proxies
+	// PurgeAfter is how long deleted users can be restored; 0 keeps them
+	PurgeAfter    time.Duration `config:"purge-after" usage:"how long deleted users can be restored before they are purged; 0 disables purging"`
+	PurgeInterval time.Duration `config:"purge-interval" usage:"how often to purge deleted users"`
+}
+
+// defaultConfig suits development against the database of the docker setup.
+func defaultConfig() webConfig {
+	return webConfig{
+		Common: config.Common{Env: config.Development},
+		Port:   8080,
+		Database: config.Database{
+			DSN:     config.DefaultDSN,
+			Timeout: dbrepo.DefaultTimeout,
+			Migrate: true,
+		},
+		Passwords:     config.Passwords{Hash: "bcrypt", BcryptCost: data.DefaultBcryptCost},
+		OIDC:          config.OIDC{RedirectURL: "http://localhost:8080/login/oidc/callback"},
+		PurgeAfter:    30 * 24 * time.Hour,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// Validate checks the settings config.Load cannot.
+func (c *webConfig) Validate() error {
+	if c.PurgeAfter > 0 && c.PurgeInterval <= 0 {
+		return fmt.Errorf("purge-interval must be positive while purge-after is set, but is %s", c.PurgeInterval)
+	}
+	return nil
+}
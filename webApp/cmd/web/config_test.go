@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"webApp/pkg/config"
+)
+
+func Test_defaultConfig(t *testing.T) {
+	var tests = []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"development", nil, ""},
+		{"no purge interval", []string{"-purge-interval", "0s"}, "purge-interval"},
+		{"negative purge interval", []string{"-purge-interval", "-1h"}, "purge-interval"},
+		{"purging disabled", []string{"-purge-after", "0s", "-purge-interval", "0s"}, ""},
+		{"trusted proxies", []string{"-trusted-proxies", "10.0.0.1, 10.1.0.0/16"}, ""},
+		{"bad trusted proxy", []string{"-trusted-proxies", "proxy.example.com"}, "trusted proxy"},
+	}
+
+	for _, e := range tests {
+		fs := flag.NewFlagSet("web", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+
+		cfg := defaultConfig()
+		err := config.Load(fs, &cfg, e.args)
+
+		switch {
+		case e.expected == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		case e.expected != "" && (err == nil || !strings.Contains(err.Error(), e.expected)):
+			t.Errorf("%s: expected an error about %q, but got %v", e.name, e.expected, err)
+		}
+	}
+}
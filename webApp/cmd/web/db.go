@@ -1,36 +1,68 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	stderrors "errors"
 	"log"
-	//sqlがdriverを探すときに使う
-	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"net/http"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/dbrepo"
+	"webApp/pkg/repository/migrations"
 )
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+// connectToDB opens the database app.DSN names: Postgres, or SQLite for a DSN
+// starting with sqlite:. It returns the connection and its driver name.
+func (app *application) connectToDB() (*sql.DB, string, error) {
+	connection, driver, err := dbrepo.Open(app.DSN)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	//ping()→dbと通信できているかのテストをしている
-	err = db.Ping()
-	if err != nil {
-		return nil, err
+	if driver == dbrepo.DriverSQLite {
+		log.Println("Connected to SQLite!")
+	} else {
+		log.Println("Connected to Postgres!")
 	}
 
-	return db, nil
+	return connection, driver, nil
+}
+
+// notFound reports whether err from the repository means a row does not exist
+func notFound(err error) bool {
+	return stderrors.Is(err, repository.ErrNotFound)
+}
+
+// dbError answers a request that failed in the repository with the status it
+// calls for: 404 when something does not exist, 409 when it clashes with
+// existing data, and 500 when the database failed. Only the repository's own
+// message is sent, never the database's, which names tables and constraints.
+func (app *application) dbError(w http.ResponseWriter, err error) {
+	switch {
+	case notFound(err):
+		http.Error(w, repository.ErrNotFound.Error(), http.StatusNotFound)
+	case stderrors.Is(err, repository.ErrDuplicateEmail):
+		http.Error(w, repository.ErrDuplicateEmail.Error(), http.StatusConflict)
+	case stderrors.Is(err, repository.ErrConflict):
+		log.Println(err)
+		http.Error(w, repository.ErrConflict.Error(), http.StatusConflict)
+	default:
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
 }
 
-func (app *application) connectToDB() (*sql.DB, error) {
-	connection, err := openDB(app.DSN)
+// migrate brings the database schema up to date
+func (app *application) migrate(conn *sql.DB, driver string) error {
+	migrator, err := migrations.New(conn, driver)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	log.Println("Connected to Postgres!")
+	applied, err := migrator.Up(context.Background())
+	for _, m := range applied {
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+	}
 
-	return connection, nil
-}
\ No newline at end of file
+	return err
+}
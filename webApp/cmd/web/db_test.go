@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webApp/pkg/repository"
+)
+
+func Test_app_dbError(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"not found", fmt.Errorf("%w: no rows in result set", repository.ErrNotFound), http.StatusNotFound, repository.ErrNotFound.Error()},
+		{"duplicate email", repository.ErrDuplicateEmail, http.StatusConflict, repository.ErrDuplicateEmail.Error()},
+		{"conflict", fmt.Errorf(`%w: duplicate key value violates unique constraint "user_images_pkey"`, repository.ErrConflict), http.StatusConflict, repository.ErrConflict.Error()},
+		{"database down", fmt.Errorf(`relation "users" does not exist`), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		app.dbError(rr, e.err)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		// the database's own message names tables and constraints
+		if body := strings.TrimSpace(rr.Body.String()); body != e.expectedBody {
+			t.Errorf("%s: expected body %q but got %q", e.name, e.expectedBody, body)
+		}
+	}
+}
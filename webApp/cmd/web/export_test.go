@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/export"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_ExportUsers(t *testing.T) {
+	var tests = []struct {
+		name                string
+		userID              int
+		url                 string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedFirstLine   string
+	}{
+		{"admin, csv", 1, "/user/export", http.StatusOK, "text/csv; charset=utf-8", "id,first_name,last_name,email,is_admin"},
+		{"admin, ndjson", 1, "/user/export?format=ndjson", http.StatusOK, export.NDJSON, `{"id":1,`},
+		{"not an admin", 2, "/user/export", http.StatusSeeOther, "", ""},
+	}
+
+	for _, e := range tests {
+		app.DB = dbrepo.NewTestDBRepo()
+
+		req := httptest.NewRequest("GET", e.url, nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.ExportUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if e.expectedStatusCode != http.StatusOK {
+			if rr.Header().Get("Location") != "/user/profile" {
+				t.Errorf("%s: expected redirect to profile but got %q", e.name, rr.Header().Get("Location"))
+			}
+			continue
+		}
+
+		if rr.Header().Get("Content-Type") != e.expectedContentType {
+			t.Errorf("%s: expected content type %q but got %q", e.name, e.expectedContentType, rr.Header().Get("Content-Type"))
+		}
+
+		if !strings.HasPrefix(rr.Body.String(), e.expectedFirstLine) {
+			t.Errorf("%s: unexpected body %q", e.name, rr.Body.String())
+		}
+
+		// both active test users, plus the header row in csv
+		lines := strings.Count(rr.Body.String(), "\n")
+		if lines < 2 {
+			t.Errorf("%s: expected a line per user but got %d", e.name, lines)
+		}
+	}
+}
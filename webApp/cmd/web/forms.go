@@ -3,36 +3,20 @@ package main
 import (
 	"net/url"
 	"strings"
+	"webApp/pkg/validator"
 )
 
-// errors is a convenience type, so that we can have a function tied to our map.
-type errors map[string][]string
-
-func (e errors) Get(field string) string {
-	errorSlice := e[field]
-	if len(errorSlice) == 0 {
-		return ""
-	}
-
-	return errorSlice[0]
-}
-
-// Add adds an error message for a given form field.
-func (e errors) Add(field, message string) {
-	e[field] = append(e[field], message)
-}
-
 // Form is the type used to instantiate form validation
 type Form struct {
 	Data url.Values
-	Errors errors
+	Errors validator.Errors
 }
 
 // NewForm initializes a form struct
 func NewForm(data url.Values) *Form {
 	return &Form{
 		Data: data,
-		Errors: map[string][]string{},
+		Errors: validator.Errors{},
 	}
 }
 
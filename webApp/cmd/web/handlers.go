@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"io"
@@ -11,6 +12,8 @@ import (
 	"path/filepath"
 	"time"
 	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+	"webApp/pkg/qrcode"
 )
 
 // go run ./cmd/webをするときとテストで自国するときではtemplate folderの位置が変わってくるから
@@ -28,11 +31,33 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 		//rのcontextに追加されたsession情報(pointer)経由でsession dataの値を変更している
 		app.Session.Put(r.Context(), "test", "Hit this page at "+time.Now().UTC().String())
 	}
+	td["oidc"] = app.OIDC != nil
 	_ = app.render(w, r, "home.page.gohtml", &TemplateData{Data: td})
 }
 
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
-	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
+	var td = make(map[string]any)
+
+	// two-factor status, and the enrollment details while it is not confirmed yet
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	if userMFA, err := app.DB.GetUserMFA(r.Context(), user.ID); err == nil {
+		td["mfa_enabled"] = userMFA.Enabled
+		if !userMFA.Enabled {
+			td["mfa_secret"] = userMFA.Secret
+			// drawn on the server so no script sees the secret
+			uri := mfa.ProvisioningURI(mfaIssuer, user.Email, userMFA.Secret)
+			if code, err := qrcode.Encode(uri); err == nil {
+				td["mfa_qr"] = template.HTML(code.SVG(4))
+			}
+		}
+	}
+
+	// recovery codes are put in the session right after enrollment and shown only once
+	if codes, ok := app.Session.Pop(r.Context(), "mfa_recovery_codes").([]string); ok {
+		td["mfa_recovery_codes"] = codes
+	}
+
+	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Data: td})
 }
 
 type TemplateData struct {
@@ -94,17 +119,23 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
-	user, err := app.DB.GetUserByEmail(email)
-	if err != nil {
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
+	if notFound(err) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Details: map[string]any{"email": email}})
 		// redirect to the login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
 
 	// authenticate the user
 	// if not authenticated then redirect with error
 	if !app.authenticate(r, user, password) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, TargetUserID: user.ID, Details: map[string]any{"email": email}})
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -114,6 +145,14 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	// sessionIDを変更して新しいsessionDataを再発行してr.contextに返すことによってsessionIDを再登録する→この時にloadAndSaveで登録したpointerと連動してdata store内の内容も変更される loadAndSave middlewareの脱出時にcookieとして登録される
 	_ = app.Session.RenewToken(r.Context())
 
+	// users with two-factor enabled still have to enter a code
+	if app.Session.Exists(r.Context(), "mfa_user_id") {
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID})
+
 	// redirect to some other page
 	app.Session.Put(r.Context(), "flash", "Successfully logged in!")
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
@@ -124,10 +163,45 @@ func (app *application) authenticate(r *http.Request, user *data.User, password
 		return false
 	}
 
+	app.rehashPassword(r.Context(), user, password)
+
+	return app.logUserIn(r, user)
+}
+
+// logUserIn puts an authenticated user in the session. Users with two-factor
+// enabled are only remembered for the second step; the user is put in the
+// session once that succeeds.
+func (app *application) logUserIn(r *http.Request, user *data.User) bool {
+	enabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if enabled {
+		app.Session.Put(r.Context(), "mfa_user_id", user.ID)
+		app.Session.Remove(r.Context(), "mfa_attempts")
+		return true
+	}
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
 	app.Session.Put(r.Context(), "user", user)
 	return true
 }
 
+// rehashPassword stores a new hash for password when the user's current one was
+// made with outdated parameters. A failure here does not fail the login.
+func (app *application) rehashPassword(ctx context.Context, user *data.User, password string) {
+	if app.Hasher == nil || !app.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	err := app.DB.ResetPassword(ctx, user.ID, password)
+	if err != nil {
+		log.Println("could not rehash password:", err)
+	}
+}
+
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts a file from an upload (request)
 	files, err := app.UploadFiles(r, uploadPath)
@@ -146,21 +220,23 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	}
 
 	// insert the user image into user_images
-	_, err = app.DB.InsertUserImage(i)
+	_, err = app.DB.InsertUserImage(r.Context(), i)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.dbError(w, err)
 		return
 	}
 
 	// refresh the sessional variable "user"
-	updatedUser, err := app.DB.GetUser(user.ID)
+	updatedUser, err := app.DB.GetUser(r.Context(), user.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.dbError(w, err)
 		return
 	}
 
 	app.Session.Put(r.Context(), "user", updatedUser)
 
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditProfilePicUploaded, TargetUserID: user.ID, Details: map[string]any{"file_name": i.FileName}})
+
 	// redirect back to profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
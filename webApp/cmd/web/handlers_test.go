@@ -18,6 +18,7 @@ import (
 	"sync"
 	"testing"
 	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -221,6 +222,15 @@ func Test_app_Login(t *testing.T) {
 			expectedStatusCode: http.StatusSeeOther,
 			expectedLoc:        "/",
 		},
+		{
+			name: "valid login with mfa",
+			postedData: url.Values{
+				"email":    {"mfa@example.com"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLoc:        "/login/mfa",
+		},
 	}
 
 	for _, e := range tests {
@@ -367,4 +377,51 @@ func Test_app_UploadProfilePic(t *testing.T) {
 	}
 
 	_ = os.Remove("./testdata/uploads/img.png")
-}
\ No newline at end of file
+}
+
+// resetRecorder remembers which users had their password reset
+type resetRecorder struct {
+	*dbrepo.MemoryDBRepo
+	resetIDs []int
+}
+
+func (m *resetRecorder) ResetPassword(ctx context.Context, id int, password string) error {
+	m.resetIDs = append(m.resetIDs, id)
+	return nil
+}
+
+func Test_app_authenticateRehashesPassword(t *testing.T) {
+	oldDB := app.DB
+	defer func() {
+		app.DB = oldDB
+		app.Hasher = nil
+	}()
+
+	// the test user's hash uses bcrypt cost 14
+	var tests = []struct {
+		name         string
+		hasher       data.PasswordHasher
+		expectRehash bool
+	}{
+		{"current cost", data.BcryptHasher{Cost: 14}, false},
+		{"outdated cost", data.BcryptHasher{Cost: 12}, true},
+	}
+
+	for _, e := range tests {
+		recorder := &resetRecorder{MemoryDBRepo: dbrepo.NewTestDBRepo()}
+		app.DB = recorder
+		app.Hasher = e.hasher
+
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req = addContextAndSessionToRequest(req, app)
+
+		user, _ := recorder.GetUserByEmail(context.Background(), "admin@example.com")
+		if !app.authenticate(req, user, "secret") {
+			t.Errorf("%s: expected authenticate to succeed", e.name)
+		}
+
+		if e.expectRehash != (len(recorder.resetIDs) == 1) {
+			t.Errorf("%s: expected rehash %t, got resets %v", e.name, e.expectRehash, recorder.resetIDs)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"webApp/pkg/clientip"
+	"webApp/pkg/config"
 	"webApp/pkg/data"
+	"webApp/pkg/oidc"
 	"webApp/pkg/repository"
 	"webApp/pkg/repository/dbrepo"
 
@@ -16,6 +22,10 @@ type application struct {
 	DSN     string
 	DB      repository.DatabaseRepo
 	Session *scs.SessionManager
+	Hasher  data.PasswordHasher
+	OIDC    *oidc.Client
+	// ClientIP finds the address of a client behind the trusted proxies
+	ClientIP *clientip.Resolver
 }
 
 func main() {
@@ -24,27 +34,70 @@ func main() {
 	// set up an app config
 	app := application{}
 
-	//commandLineで使用できるdsn flagを作りそれをapp.DSNに代入する
+	// 既定値、設定ファイル、環境変数、flagの順に設定を読み込む
+	cfg := defaultConfig()
+	err := config.Load(flag.CommandLine, &cfg, os.Args[1:])
+	if err == config.ErrPrinted {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Configuration:\n%s", config.String(&cfg))
+
 	// 主導でdbに接続するために用意
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.Parse()
+	app.DSN = cfg.Database.DSN
 
-	conn, err := app.connectToDB()
+	hasher, err := data.NewPasswordHasher(cfg.Passwords.Hash, cfg.Passwords.BcryptCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Hasher = hasher
+
+	app.ClientIP, err = clientip.New(cfg.Proxies.Trusted)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.OIDC.IssuerURL != "" {
+		app.OIDC, err = oidc.NewClient(context.Background(), oidc.Config{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	conn, driver, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if cfg.Database.Migrate {
+		err = app.migrate(conn, driver)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.DB = dbrepo.New(conn, driver, app.Hasher, cfg.Database.Timeout)
+
+	if cfg.PurgeAfter > 0 {
+		go app.runPurgeJob(context.Background(), cfg.PurgeAfter, cfg.PurgeInterval)
+	}
 
 	// get a session manager
 	app.Session = getSession()
 
 	// print out a message
-	log.Println("Starting server on port 8080...")
+	log.Printf("Starting server on port %d...", cfg.Port)
 
 	// start the server
-	err = http.ListenAndServe(":8080", app.routes())
+	err = http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), app.routes())
 	if err != nil {
 		log.Fatal(err)
 	}
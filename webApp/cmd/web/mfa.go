@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+)
+
+// mfaIssuer is the name shown next to the account in authenticator apps
+var mfaIssuer = "webApp"
+
+// maxMFAAttempts is how many wrong codes we accept before the login has to start over
+const maxMFAAttempts = 5
+
+// mfaEnabled reports whether the user has confirmed two-factor authentication.
+// Errors other than "no settings stored" are returned, so that callers fail closed.
+func (app *application) mfaEnabled(ctx context.Context, userID int) (bool, error) {
+	userMFA, err := app.DB.GetUserMFA(ctx, userID)
+	if err != nil {
+		if notFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return userMFA.Enabled, nil
+}
+
+// verifyMFACode checks code with mfa.Verify, logging the errors that are not
+// about the code. It returns mfa.ErrLocked while the user is locked out.
+func (app *application) verifyMFACode(ctx context.Context, userID int, code string) (bool, error) {
+	err := mfa.Verify(ctx, app.DB, userID, code, time.Now())
+	switch {
+	case err == nil:
+		return true, nil
+	case err == mfa.ErrLocked:
+		return false, err
+	case err != mfa.ErrInvalidCode:
+		log.Println(err)
+	}
+	return false, nil
+}
+
+func (app *application) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if !app.Session.Exists(r.Context(), "mfa_user_id") {
+		app.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{})
+}
+
+func (app *application) VerifyLoginMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	userID := app.Session.GetInt(r.Context(), "mfa_user_id")
+	if userID == 0 {
+		app.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+
+	valid := false
+	if form.Valid() {
+		valid, err = app.verifyMFACode(r.Context(), userID, r.Form.Get("code"))
+	}
+
+	if !valid {
+		// too many wrong codes: throw away the half finished login
+		attempts := app.Session.GetInt(r.Context(), "mfa_attempts") + 1
+		if attempts >= maxMFAAttempts || err == mfa.ErrLocked {
+			message := "Too many invalid codes, log in again"
+			if err == mfa.ErrLocked {
+				message = "Too many invalid codes, try again later"
+			}
+
+			app.Session.Remove(r.Context(), "mfa_user_id")
+			app.Session.Remove(r.Context(), "mfa_attempts")
+			app.Session.Put(r.Context(), "error", message)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		app.Session.Put(r.Context(), "mfa_attempts", attempts)
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Remove(r.Context(), "mfa_attempts")
+
+	// prevent fixation attack
+	_ = app.Session.RenewToken(r.Context())
+
+	app.Session.Put(r.Context(), "user", *user)
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID, Details: map[string]any{"method": "mfa"}})
+	app.Session.Put(r.Context(), "flash", "Successfully logged in!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// EnrollMFA starts two-factor enrollment by storing a new, not yet enabled secret.
+func (app *application) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	enabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		app.Session.Put(r.Context(), "error", "Two-factor authentication is already enabled")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.SaveUserMFA(r.Context(), data.UserMFA{UserID: user.ID, Secret: secret})
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// ConfirmMFA enables two-factor authentication once the user proves the
+// authenticator app works, and hands out the recovery codes.
+func (app *application) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	userMFA, err := app.DB.GetUserMFA(r.Context(), user.ID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Start two-factor enrollment first")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	form.Check(!userMFA.Enabled, "code", "Two-factor authentication is already enabled")
+
+	step, ok := mfa.ValidateStep(r.Form.Get("code"), userMFA.Secret, time.Now())
+	if !form.Valid() || !ok {
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	// so the code cannot be used again to log in
+	_, err = app.DB.UseTOTPStep(r.Context(), user.ID, step)
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userMFA.Enabled = true
+	userMFA.RecoveryCodes = nil
+	for _, c := range codes {
+		userMFA.RecoveryCodes = append(userMFA.RecoveryCodes, mfa.HashRecoveryCode(c))
+	}
+
+	err = app.DB.SaveUserMFA(r.Context(), *userMFA)
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	// the plain codes are only ever shown once, on the next profile page view
+	app.Session.Put(r.Context(), "mfa_recovery_codes", codes)
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication enabled")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// DisableMFA turns two-factor authentication off; it requires a current code
+// or a recovery code.
+func (app *application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+
+	valid := false
+	if form.Valid() {
+		valid, err = app.verifyMFACode(r.Context(), user.ID, r.Form.Get("code"))
+	}
+
+	if err == mfa.ErrLocked {
+		app.Session.Put(r.Context(), "error", "Too many invalid codes, try again later")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	if !valid {
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.DeleteUserMFA(r.Context(), user.ID)
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication disabled")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_VerifyLoginMFA(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	code, _ := mfa.Code(dbrepo.TestMFASecret, time.Now())
+
+	var tests = []struct {
+		name             string
+		mfaUserID        int
+		attempts         int
+		code             string
+		expectedLoc      string
+		expectLoggedInAs int
+	}{
+		{"valid code", 2, 0, code, "/user/profile", 2},
+		{"replayed code", 2, 0, code, "/login/mfa", 0},
+		{"recovery code", 2, 0, dbrepo.TestRecoveryCode, "/user/profile", 2},
+		{"wrong code", 2, 0, "000000", "/login/mfa", 0},
+		{"missing code", 2, 0, "", "/login/mfa", 0},
+		{"too many attempts", 2, maxMFAAttempts - 1, "000000", "/", 0},
+		{"no password step", 0, 0, code, "/", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if e.mfaUserID > 0 {
+			app.Session.Put(req.Context(), "mfa_user_id", e.mfaUserID)
+		}
+		if e.attempts > 0 {
+			app.Session.Put(req.Context(), "mfa_attempts", e.attempts)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.VerifyLoginMFA)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, err := rr.Result().Location()
+		if err != nil {
+			t.Errorf("%s: no location header set", e.name)
+		} else if actualLoc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, actualLoc.String())
+		}
+
+		user, ok := app.Session.Get(req.Context(), "user").(data.User)
+		if e.expectLoggedInAs > 0 && (!ok || user.ID != e.expectLoggedInAs) {
+			t.Errorf("%s: expected user %d in session", e.name, e.expectLoggedInAs)
+		}
+		if e.expectLoggedInAs == 0 && ok {
+			t.Errorf("%s: did not expect a user in session", e.name)
+		}
+	}
+}
+
+func Test_app_VerifyLoginMFALockout(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	defer func() { app.DB = dbrepo.NewTestDBRepo() }()
+
+	// each login starts a new session, so only the count kept with the user
+	// stops the guessing
+	for i := 0; i <= mfa.MaxAttempts; i++ {
+		code := "000000"
+		if i == mfa.MaxAttempts {
+			code, _ = mfa.Code(dbrepo.TestMFASecret, time.Now())
+		}
+
+		postedData := url.Values{"code": {code}}
+		req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.Session.Put(req.Context(), "mfa_user_id", 2)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.VerifyLoginMFA).ServeHTTP(rr, req)
+
+		if _, ok := app.Session.Get(req.Context(), "user").(data.User); ok {
+			t.Fatalf("attempt %d: expected no login", i+1)
+		}
+		if i == mfa.MaxAttempts && app.Session.GetString(req.Context(), "error") != "Too many invalid codes, try again later" {
+			t.Errorf("expected a valid code to be refused while locked out, but got %q", app.Session.GetString(req.Context(), "error"))
+		}
+	}
+}
+
+func Test_app_LoginMFA(t *testing.T) {
+	var tests = []struct {
+		name           string
+		mfaUserID      int
+		expectedStatus int
+	}{
+		{"after password step", 2, http.StatusOK},
+		{"without password step", 0, http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/login/mfa", nil)
+		req = addContextAndSessionToRequest(req, app)
+		if e.mfaUserID > 0 {
+			app.Session.Put(req.Context(), "mfa_user_id", e.mfaUserID)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.LoginMFA)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_mfaProfileHandlers(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+	code, _ := mfa.Code(dbrepo.TestMFASecret, time.Now())
+
+	var tests = []struct {
+		name          string
+		handler       http.HandlerFunc
+		userID        int
+		code          string
+		expectedFlash bool
+	}{
+		{"enroll already enabled", app.EnrollMFA, 2, "", false},
+		{"enroll", app.EnrollMFA, 1, "", false},
+		{"confirm without enrollment", app.ConfirmMFA, 1, code, false},
+		{"confirm already enabled", app.ConfirmMFA, 2, code, false},
+		{"disable wrong code", app.DisableMFA, 2, "000000", false},
+		{"disable", app.DisableMFA, 2, code, true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if app.Session.Exists(req.Context(), "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash to be set: %t", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func Test_app_ProfileRecoveryCodesShownOnce(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), "mfa_recovery_codes", []string{"abcde-12345"})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Profile)
+	handler.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "abcde-12345") {
+		t.Error("recovery codes not shown on profile page")
+	}
+
+	if app.Session.Exists(req.Context(), "mfa_recovery_codes") {
+		t.Error("recovery codes still in session after being shown")
+	}
+}
+
+func Test_app_ProfileShowsEnrollmentQRCode(t *testing.T) {
+	app.DB = dbrepo.NewTestDBRepo()
+
+	req, _ := http.NewRequest("POST", "/user/mfa/enroll", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+	http.HandlerFunc(app.EnrollMFA).ServeHTTP(httptest.NewRecorder(), req)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Profile).ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `<svg xmlns="http://www.w3.org/2000/svg"`) {
+		t.Error("enrollment QR code not drawn on profile page")
+	}
+	if strings.Contains(rr.Body.String(), "<script") {
+		t.Error("did not expect a script on the profile page")
+	}
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
 	"net/http"
 )
 
@@ -17,47 +15,17 @@ func (app *application) ipFromContext(ctx context.Context) string {
 
 func (app *application) addIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.Background()
-		// get the ip (as accurately as possible)
-		ip, err := getIP(r)
-		if err != nil {
-			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		} else {
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
+		// get the ip (as accurately as possible); X-Forwarded-For only counts
+		// when a trusted proxy sent the request
+		ip := app.ClientIP.IP(r)
+		if len(ip) == 0 {
+			ip = "unknown"
 		}
+		ctx := context.WithValue(r.Context(), contextUserKey, ip)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "unknown", err
-	}
-
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userip: %q is not IP:port", r.RemoteAddr)
-	}
-
-	//is it IPAdress coming from proxy
-	forward := r.Header.Get("X-Forwarded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-
-	if len(ip) == 0 {
-		ip = "forward"
-	}
-
-	return ip, nil
-}
-
 func (app *application) auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.Session.Exists(r.Context(), "user") {
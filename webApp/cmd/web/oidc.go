@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"webApp/pkg/data"
+	"webApp/pkg/oidc"
+)
+
+// LoginOIDC sends the user to the identity provider to sign in.
+func (app *application) LoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.Session.Put(r.Context(), "error", "Single sign-on is not configured")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// kept in the session until the provider sends the user back
+	app.Session.Put(r.Context(), "oidc_state", state)
+	app.Session.Put(r.Context(), "oidc_nonce", nonce)
+	app.Session.Put(r.Context(), "oidc_verifier", verifier)
+
+	http.Redirect(w, r, app.OIDC.AuthCodeURL(state, nonce, challenge), http.StatusSeeOther)
+}
+
+// OIDCCallback finishes signing in once the identity provider sends the user back.
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.Session.Put(r.Context(), "error", "Single sign-on is not configured")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// state, nonce and verifier are single use
+	state := app.Session.PopString(r.Context(), "oidc_state")
+	nonce := app.Session.PopString(r.Context(), "oidc_nonce")
+	verifier := app.Session.PopString(r.Context(), "oidc_verifier")
+
+	q := r.URL.Query()
+	if q.Get("error") != "" || state == "" || q.Get("state") != state {
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := app.OIDC.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Println(err)
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := oidc.ResolveUser(r.Context(), app.DB, app.OIDC.Issuer(), claims)
+	if err != nil {
+		log.Println(err)
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !app.logUserIn(r, user) {
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// prevent fixation attack
+	_ = app.Session.RenewToken(r.Context())
+
+	// users with two-factor enabled still have to enter a code
+	if app.Session.Exists(r.Context(), "mfa_user_id") {
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, TargetUserID: user.ID, Details: map[string]any{"method": "oidc"}})
+
+	app.Session.Put(r.Context(), "flash", "Successfully logged in!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
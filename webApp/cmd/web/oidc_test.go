@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/oidc"
+	"webApp/pkg/oidc/oidctest"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_OIDCLogin(t *testing.T) {
+	provider := oidctest.NewProvider()
+	defer provider.Close()
+
+	client, err := oidc.NewClient(context.Background(), provider.Config("http://localhost:8080/login/oidc/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.OIDC = client
+	defer func() { app.OIDC = nil }()
+
+	var tests = []struct {
+		name           string
+		email          string
+		verified       bool
+		tamperState    bool
+		expectedLoc    string
+		expectedUserID int
+	}{
+		{"new user provisioned", "new@example.com", true, false, "/user/profile", 4},
+		{"admin email not linked", "admin@example.com", true, false, "/", 0},
+		{"state mismatch", "new@example.com", true, true, "/", 0},
+		{"unverified email", "new@example.com", false, false, "/", 0},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, e := range tests {
+		// every case starts from the fixtures, whatever the previous one changed
+		app.DB = dbrepo.NewTestDBRepo()
+
+		provider.User.Email = e.email
+		provider.User.EmailVerified = e.verified
+
+		// start the login, which sends us to the provider
+		req, _ := http.NewRequest("GET", "/login/oidc", nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.LoginOIDC).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("%s: expected redirect to provider, got %d", e.name, rr.Code)
+		}
+
+		authURL, _ := rr.Result().Location()
+		callback, err := provider.Authorize(authURL.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.tamperState {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}
+
+		// come back from the provider, with the same session
+		req2, _ := http.NewRequest("GET", callback.RequestURI(), nil)
+		req2 = req2.WithContext(req.Context())
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(app.OIDCCallback).ServeHTTP(rr, req2)
+
+		loc, err := rr.Result().Location()
+		if err != nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %v", e.name, e.expectedLoc, loc)
+		}
+
+		user, ok := app.Session.Get(req2.Context(), "user").(*data.User)
+		if e.expectedUserID > 0 && (!ok || user.ID != e.expectedUserID) {
+			t.Errorf("%s: expected user %d in session", e.name, e.expectedUserID)
+		}
+		if e.expectedUserID == 0 && ok {
+			t.Errorf("%s: did not expect a user in session", e.name)
+		}
+
+		if app.Session.Exists(req2.Context(), "oidc_state") {
+			t.Errorf("%s: state should be removed from the session", e.name)
+		}
+	}
+}
+
+func Test_app_OIDCNotConfigured(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{app.LoginOIDC, app.OIDCCallback} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		loc, err := rr.Result().Location()
+		if err != nil || loc.String() != "/" {
+			t.Errorf("expected redirect to /, got %v", loc)
+		}
+	}
+}
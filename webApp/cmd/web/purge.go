@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	"webApp/pkg/data"
+)
+
+// purgeDeletedUsers permanently removes the users deleted longer than
+// retention ago, and the profile pictures no one else uses.
+func (app *application) purgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error) {
+	ids, files, err := app.DB.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range files {
+		// uploads are stored by their base name, see UploadFiles
+		err := os.Remove(filepath.Join(uploadPath, filepath.Base(file)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("could not remove profile picture:", err)
+		}
+	}
+
+	for _, id := range ids {
+		_, err := app.DB.InsertAuditEvent(ctx, data.AuditEvent{Action: data.AuditUserPurged, TargetUserID: id})
+		if err != nil {
+			log.Println("could not record audit event:", err)
+		}
+	}
+
+	return len(ids), nil
+}
+
+// runPurgeJob calls purgeDeletedUsers every interval, until ctx is done.
+func (app *application) runPurgeJob(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.purgeDeletedUsers(ctx, retention)
+		if err != nil {
+			log.Println("purging deleted users failed:", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted users", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_purgeDeletedUsers(t *testing.T) {
+	oldUploadPath := uploadPath
+	uploadPath = t.TempDir()
+	defer func() { uploadPath = oldUploadPath }()
+
+	picture := filepath.Join(uploadPath, dbrepo.TestDeletedUserImage)
+	other := filepath.Join(uploadPath, "someone-else.png")
+	for _, f := range []string{picture, other} {
+		if err := os.WriteFile(f, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := app.purgeDeletedUsers(context.Background(), 30 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("expected 1 purged user, but got %d", n)
+	}
+
+	if _, err := os.Stat(picture); !os.IsNotExist(err) {
+		t.Error("profile picture of purged user was not removed")
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Error("removed a picture that does not belong to a purged user")
+	}
+
+	// running again with the file gone is not an error
+	if _, err := app.purgeDeletedUsers(context.Background(), 30 * 24 * time.Hour); err != nil {
+		t.Error(err)
+	}
+}
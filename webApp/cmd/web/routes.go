@@ -20,11 +20,20 @@ func (app *application) routes() http.Handler {
 	// register routes
 	mux.Get("/", app.Home)
 	mux.Post("/login", app.Login)
+	mux.Get("/login/mfa", app.LoginMFA)
+	mux.Post("/login/mfa", app.VerifyLoginMFA)
+	mux.Get("/login/oidc", app.LoginOIDC)
+	mux.Get("/login/oidc/callback", app.OIDCCallback)
 
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
 		mux.Get("/profile", app.Profile)
 		mux.Post("/upload-profile-pic", app.UploadProfilePic)
+		mux.Post("/mfa/enroll", app.EnrollMFA)
+		mux.Post("/mfa/confirm", app.ConfirmMFA)
+		mux.Post("/mfa/disable", app.DisableMFA)
+		mux.Get("/export", app.ExportUsers)
+		mux.Get("/search", app.SearchUsers)
 	})
 
 	// static assets
@@ -15,7 +15,16 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{"/", "GET"},
 		{"/login", "POST"},
+		{"/login/mfa", "GET"},
+		{"/login/mfa", "POST"},
+		{"/login/oidc", "GET"},
+		{"/login/oidc/callback", "GET"},
 		{"/user/profile", "GET"},
+		{"/user/mfa/enroll", "POST"},
+		{"/user/mfa/confirm", "POST"},
+		{"/user/mfa/disable", "POST"},
+		{"/user/export", "GET"},
+		{"/user/search", "GET"},
 		{"/static/*", "GET"},
 	}
 
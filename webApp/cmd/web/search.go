@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// searchLimit is how many users the user search box suggests
+const searchLimit = 10
+
+// SearchUsers answers the admin's user search box with the users whose names
+// or email begin with the words of ?q=, best match first, as JSON.
+func (app *application) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	admin, err := app.isAdmin(r)
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	if !admin {
+		http.Error(w, "Only admins can search users", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if err := repository.CheckSearch(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := app.DB.SearchUsers(r.Context(), query, searchLimit)
+	if err != nil {
+		app.dbError(w, err)
+		return
+	}
+
+	if users == nil {
+		users = []*data.User{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		log.Println(err)
+	}
+}
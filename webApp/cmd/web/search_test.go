@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_app_SearchUsers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             int
+		query              string
+		expectedStatusCode int
+		expectedIDs        []int
+	}{
+		{"admin", 1, "?q=mfa", http.StatusOK, []int{2}},
+		{"no match", 1, "?q=nobody", http.StatusOK, []int{}},
+		{"not an admin", 2, "?q=mfa", http.StatusForbidden, nil},
+		{"query too long", 1, "?q=" + strings.Repeat("a", 101), http.StatusBadRequest, nil},
+		{"longest query", 1, "?q=" + strings.Repeat("é", 100), http.StatusOK, []int{}},
+	}
+
+	app.DB = dbrepo.NewTestDBRepo()
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/search"+e.query, nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.SearchUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if e.expectedIDs == nil {
+			continue
+		}
+
+		var users []data.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if len(users) != len(e.expectedIDs) {
+			t.Errorf("%s: expected users %v but got %v", e.name, e.expectedIDs, users)
+			continue
+		}
+		for i, id := range e.expectedIDs {
+			if users[i].ID != id {
+				t.Errorf("%s: expected users %v but got %v", e.name, e.expectedIDs, users)
+			}
+		}
+	}
+}
@@ -15,7 +15,7 @@ func TestMain(m *testing.M) {
 
 	app.Session = getSession()
 
-	app.DB = &dbrepo.TestDBRepo{}
+	app.DB = dbrepo.NewTestDBRepo()
 
 	// it runs all of tests
 	os.Exit(m.Run())
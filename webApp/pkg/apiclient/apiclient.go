@@ -0,0 +1,357 @@
+// Package apiclient is a Go client for the users API served by cmd/api, as
+// described by cmd/api/openapi.json. It signs in, renews tokens when they
+// expire, retries requests that are safe to repeat, and turns the API's
+// problem details into errors.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiVersion is the prefix of the version of the API the client speaks
+const apiVersion = "/v1"
+
+// Config holds the settings of a client.
+type Config struct {
+	// BaseURL is the root the API is served at, without the version, e.g.
+	// http://localhost:8090
+	BaseURL string
+	// APIKey, when set, authenticates every request instead of signing in
+	APIKey string
+	// HTTPClient defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+	// MaxRetries is how often a request that is safe to repeat is retried
+	// after a network error, 429 or 502-504; defaults to 2, -1 turns retries off
+	MaxRetries int
+	// RetryWait is the wait before the first retry, doubled for each one
+	// after; defaults to 200ms. A Retry-After header takes precedence.
+	RetryWait time.Duration
+}
+
+// TokenPairs are the tokens the API hands out when a user signs in.
+type TokenPairs struct {
+	Token        string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Client talks to the users API. It is safe for concurrent use.
+type Client struct {
+	config Config
+
+	mu       sync.Mutex
+	tokens   *TokenPairs
+	email    string
+	password string
+}
+
+// New returns a client for the API config describes.
+func New(config Config) *Client {
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 2
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryWait == 0 {
+		config.RetryWait = 200 * time.Millisecond
+	}
+
+	return &Client{config: config}
+}
+
+// Tokens returns the tokens the client signed in with, or nil.
+func (c *Client) Tokens() *TokenPairs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		return nil
+	}
+	tokens := *c.tokens
+	return &tokens
+}
+
+// SetTokens makes the client use tokens obtained elsewhere.
+func (c *Client) SetTokens(tokens TokenPairs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens = &tokens
+}
+
+// Login signs in with email and password. Users with two-factor authentication
+// get an *MFARequiredError, whose token LoginMFA takes with a code. The
+// credentials are kept, to sign in again when the tokens can not be renewed.
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	body, err := json.Marshal(map[string]string{"email": email, "password": password})
+	if err != nil {
+		return err
+	}
+
+	err = c.login(ctx, "/auth", body)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.email, c.password = email, password
+	c.mu.Unlock()
+
+	return nil
+}
+
+// LoginMFA finishes signing in a user with two-factor authentication, with the
+// token from an *MFARequiredError and a TOTP or recovery code.
+func (c *Client) LoginMFA(ctx context.Context, mfaToken, code string) error {
+	body, err := json.Marshal(map[string]string{"mfa_token": mfaToken, "code": code})
+	if err != nil {
+		return err
+	}
+
+	return c.login(ctx, "/auth/mfa", body)
+}
+
+func (c *Client) login(ctx context.Context, path string, body []byte) error {
+	resp, err := c.send(ctx, http.MethodPost, path, "application/json", body, nil, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var tokens TokenPairs
+		if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+			return fmt.Errorf("apiclient: reading tokens: %w", err)
+		}
+		c.SetTokens(tokens)
+		return nil
+	case http.StatusAccepted:
+		var challenge struct {
+			Token string `json:"mfa_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+			return fmt.Errorf("apiclient: reading mfa challenge: %w", err)
+		}
+		return &MFARequiredError{Token: challenge.Token}
+	default:
+		return errorFromResponse(resp)
+	}
+}
+
+// Refresh swaps the refresh token for a new token pair. The API only does that
+// once the access token has expired or is about to, and answers ErrTooEarly
+// before.
+func (c *Client) Refresh(ctx context.Context) error {
+	tokens := c.Tokens()
+	if tokens == nil {
+		return ErrUnauthorized
+	}
+
+	form := url.Values{}
+	form.Set("refresh_token", tokens.RefreshToken)
+
+	resp, err := c.send(ctx, http.MethodPost, "/refresh-token", "application/x-www-form-urlencoded", []byte(form.Encode()), nil, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errorFromResponse(resp)
+	}
+
+	var newTokens TokenPairs
+	if err := json.NewDecoder(resp.Body).Decode(&newTokens); err != nil {
+		return fmt.Errorf("apiclient: reading tokens: %w", err)
+	}
+	c.SetTokens(newTokens)
+
+	return nil
+}
+
+// renew gets new tokens after the access token was refused: with the refresh
+// token if the API takes it, otherwise by signing in again with the
+// credentials Login kept, if there are any.
+func (c *Client) renew(ctx context.Context) error {
+	err := c.Refresh(ctx)
+	if err == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	email, password := c.email, c.password
+	c.mu.Unlock()
+
+	if email == "" {
+		return err
+	}
+	return c.Login(ctx, email, password)
+}
+
+// do sends an authenticated request with a JSON body. The tokens are refreshed
+// first when the access token is about to expire, and renewed before trying
+// once more when it is refused anyway. A response other than 2xx is returned as
+// an error; the caller closes the body of any other.
+func (c *Client) do(ctx context.Context, method, path string, body any, header http.Header) (*http.Response, error) {
+	var payload []byte
+	contentType := ""
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+		if header != nil && header.Get("Content-Type") != "" {
+			contentType = header.Get("Content-Type")
+		}
+	}
+
+	if tokens := c.Tokens(); c.config.APIKey == "" && tokens != nil && expiresSoon(tokens.Token) {
+		// a failed refresh is left to the 401 below, which also tries signing in
+		_ = c.Refresh(ctx)
+	}
+
+	resp, err := c.send(ctx, method, path, contentType, payload, header, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.config.APIKey == "" && c.Tokens() != nil {
+		resp.Body.Close()
+
+		if err := c.renew(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, err = c.send(ctx, method, path, contentType, payload, header, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, errorFromResponse(resp)
+	}
+
+	return resp, nil
+}
+
+// expiresSoon reports whether the JWT token expires within 30 seconds, the
+// window in which the API takes its refresh token. A token whose expiry can
+// not be read is left for the API to judge.
+func expiresSoon(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return false
+	}
+
+	return time.Until(time.Unix(claims.ExpiresAt, 0)) <= 30*time.Second
+}
+
+// send sends a request, and retries it as long as that is safe and worth it
+func (c *Client) send(ctx context.Context, method, path, contentType string, body []byte, header http.Header, authenticate bool) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.config.BaseURL+apiVersion+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Accept", "application/json, application/problem+json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		if authenticate {
+			if c.config.APIKey != "" {
+				req.Header.Set("X-API-Key", c.config.APIKey)
+			} else if tokens := c.Tokens(); tokens != nil {
+				req.Header.Set("Authorization", "Bearer "+tokens.Token)
+			}
+		}
+
+		resp, err := c.config.HTTPClient.Do(req)
+
+		if attempt >= c.config.MaxRetries || !idempotent(method) || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := c.config.RetryWait << attempt
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// idempotent reports whether sending a request of method twice does no more
+// than sending it once, and answers the same. PUT and DELETE are left out:
+// this API's PUTs create users and consume If-Match versions, and its DELETEs
+// answer 404 for a user already deleted or a key already revoked, so
+// repeating one that was stored before its response got lost reports an error
+// for a write that worked.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryable reports whether a request that got resp or err may succeed later
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// the caller gave up; trying again will not change that
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,181 @@
+package apiclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"webApp/pkg/apiclient"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *apiclient.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return apiclient.New(apiclient.Config{BaseURL: server.URL, RetryWait: time.Millisecond})
+}
+
+func Test_retries(t *testing.T) {
+	var tests = []struct {
+		name          string
+		method        string
+		failures      int32
+		expectedCalls int32
+		expectedErr   error
+	}{
+		{"recovers", "GET", 2, 3, nil},
+		{"gives up", "GET", 5, 3, apiclient.ErrServer},
+		{"create is not repeated", "CREATE", 1, 1, apiclient.ErrServer},
+		{"update is not repeated", "UPDATE", 1, 1, apiclient.ErrServer},
+		{"password reset is not repeated", "PUT", 1, 1, apiclient.ErrServer},
+		{"delete is not repeated", "DELETE", 1, 1, apiclient.ErrServer},
+		{"post is not repeated", "POST", 1, 1, apiclient.ErrServer},
+	}
+
+	for _, e := range tests {
+		var calls int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= e.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		})
+
+		var err error
+		switch e.method {
+		case "GET":
+			_, err = c.Users(context.Background())
+		case "CREATE":
+			err = c.CreateUser(context.Background(), apiclient.NewUser{Email: "jack@smith.com"})
+		case "UPDATE":
+			err = c.UpdateUser(context.Background(), &apiclient.User{ID: 1, ETag: `"1"`})
+		case "PUT":
+			err = c.ResetPassword(context.Background(), 1, "secret")
+		case "POST":
+			err = c.RestoreUser(context.Background(), 1)
+		case "DELETE":
+			err = c.DeleteUser(context.Background(), 1)
+		}
+
+		if e.expectedErr == nil && err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+		if e.expectedErr != nil && !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expectedErr, err)
+		}
+
+		if calls != e.expectedCalls {
+			t.Errorf("%s: expected %d calls, but got %d", e.name, e.expectedCalls, calls)
+		}
+	}
+}
+
+func Test_retryCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := apiclient.New(apiclient.Config{BaseURL: server.URL, RetryWait: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Users(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to end the retries, but got %v", err)
+	}
+}
+
+func Test_problemErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"type":"/problems/validation","title":"Invalid request","status":422,"detail":"invalid email","errors":{"email":["must be an email address"]},"request_id":"abc"}`))
+	})
+
+	err := c.CreateUser(context.Background(), apiclient.NewUser{Email: "jack"})
+	if !errors.Is(err, apiclient.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, but got %v", err)
+	}
+
+	var apiErr *apiclient.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *apiclient.Error, but got %T", err)
+	}
+
+	if apiErr.FieldErrors["email"][0] != "must be an email address" || apiErr.RequestID != "abc" || apiErr.Type != "/problems/validation" {
+		t.Errorf("problem read wrongly: %+v", apiErr)
+	}
+}
+
+func Test_renewTokens(t *testing.T) {
+	var tests = []struct {
+		name          string
+		refreshStatus int
+		login         bool
+		expectedToken string
+		expectedErr   error
+	}{
+		{"refresh", http.StatusOK, false, "refreshed", nil},
+		{"refresh too early, log in again", http.StatusTooEarly, true, "logged-in-again", nil},
+		{"refresh too early, no credentials", http.StatusTooEarly, false, "", apiclient.ErrTooEarly},
+	}
+
+	for _, e := range tests {
+		logins := 0
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/auth":
+				logins++
+				token := "first"
+				if logins > 1 {
+					token = "logged-in-again"
+				}
+				_, _ = w.Write([]byte(`{"access_token":"` + token + `","refresh_token":"r"}`))
+			case "/v1/refresh-token":
+				if r.FormValue("refresh_token") != "r" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(e.refreshStatus)
+				_, _ = w.Write([]byte(`{"access_token":"refreshed","refresh_token":"r"}`))
+			case "/v1/users/":
+				if r.Header.Get("Authorization") == "Bearer expired" || r.Header.Get("Authorization") == "Bearer first" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`[]`))
+			}
+		})
+
+		if e.login {
+			if err := c.Login(context.Background(), "admin@example.com", "secret"); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			c.SetTokens(apiclient.TokenPairs{Token: "expired", RefreshToken: "r"})
+		}
+
+		_, err := c.Users(context.Background())
+		if e.expectedErr != nil {
+			if !errors.Is(err, e.expectedErr) {
+				t.Errorf("%s: expected %v, but got %v", e.name, e.expectedErr, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if c.Tokens().Token != e.expectedToken {
+			t.Errorf("%s: expected token %s, but got %s", e.name, e.expectedToken, c.Tokens().Token)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Errors an *Error matches with errors.Is, by its status.
+var (
+	ErrBadRequest      = errors.New("apiclient: bad request")
+	ErrUnauthorized    = errors.New("apiclient: unauthorized")
+	ErrForbidden       = errors.New("apiclient: forbidden")
+	ErrNotFound        = errors.New("apiclient: not found")
+	ErrConflict        = errors.New("apiclient: conflict")
+	ErrVersionMismatch = errors.New("apiclient: version mismatch")
+	ErrTooEarly        = errors.New("apiclient: too early")
+	ErrInvalid         = errors.New("apiclient: invalid")
+	ErrServer          = errors.New("apiclient: server error")
+)
+
+// statusErrors maps the statuses the API answers with to the errors above
+var statusErrors = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusPreconditionFailed:  ErrVersionMismatch,
+	http.StatusTooEarly:            ErrTooEarly,
+	http.StatusUnprocessableEntity: ErrInvalid,
+}
+
+// Error is a request the API refused, with the RFC 7807 problem details it
+// sent. FieldErrors holds the messages for each invalid field of a payload.
+type Error struct {
+	StatusCode  int                 `json:"status"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	Detail      string              `json:"detail"`
+	Instance    string              `json:"instance"`
+	FieldErrors map[string][]string `json:"errors"`
+	RequestID   string              `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("apiclient: %d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is matches the sentinel error for e's status, e.g. ErrNotFound for 404
+func (e *Error) Is(target error) bool {
+	if e.StatusCode >= http.StatusInternalServerError {
+		return target == ErrServer
+	}
+	return statusErrors[e.StatusCode] == target
+}
+
+// MFARequiredError is returned by Login for users with two-factor
+// authentication; pass Token to LoginMFA with a code.
+type MFARequiredError struct {
+	Token string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "apiclient: two-factor authentication required"
+}
+
+// errorFromResponse reads the problem details of a failed response
+func errorFromResponse(resp *http.Response) error {
+	e := &Error{}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(body, e) != nil || e.Title == "" {
+		e.Title = http.StatusText(resp.StatusCode)
+	}
+
+	e.StatusCode = resp.StatusCode
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-Id")
+	}
+
+	return e
+}
@@ -0,0 +1,245 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// User is a user as the API sends it. ETag is the version it was read at,
+// which UpdateUser and PatchUser send back so as not to overwrite changes made
+// since; they set it to the new version.
+type User struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	IsAdmin   int    `json:"is_admin"`
+	ETag      string `json:"-"`
+}
+
+// NewUser is a user to create.
+type NewUser struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	IsAdmin   int    `json:"is_admin"`
+}
+
+// Users lists the users that are not deleted.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/users/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var users []User
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, fmt.Errorf("apiclient: reading users: %w", err)
+	}
+
+	return users, nil
+}
+
+// SearchUsers returns up to limit users whose names or email begin with the
+// words of query, best match first.
+func (c *Client) SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	params := url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}}
+
+	resp, err := c.do(ctx, http.MethodGet, "/users/search?"+params.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var users []User
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, fmt.Errorf("apiclient: reading users: %w", err)
+	}
+
+	return users, nil
+}
+
+// User returns the user with id.
+func (c *Client) User(ctx context.Context, id int) (*User, error) {
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%d", id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("apiclient: reading user: %w", err)
+	}
+	user.ETag = resp.Header.Get("ETag")
+
+	return &user, nil
+}
+
+// CreateUser creates a user.
+func (c *Client) CreateUser(ctx context.Context, user NewUser) error {
+	resp, err := c.do(ctx, http.MethodPut, "/users/", user, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// UpdateUser replaces the user with user.ID. Without an ETag any version is
+// replaced; with one a user changed since fails with ErrVersionMismatch.
+func (c *Client) UpdateUser(ctx context.Context, user *User) error {
+	resp, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/users/%d", user.ID), user, ifMatch(user.ETag))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	user.ETag = resp.Header.Get("ETag")
+	return nil
+}
+
+// PatchUser changes the fields of user in patch, an RFC 7386 merge patch, e.g.
+// {"first_name": "Jane"}, and updates user to match. The ETag works as for
+// UpdateUser.
+func (c *Client) PatchUser(ctx context.Context, user *User, patch map[string]any) error {
+	header := ifMatch(user.ETag)
+	header.Set("Content-Type", "application/merge-patch+json")
+
+	resp, err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/users/%d", user.ID), patch, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// the API sends no body, so apply the patch as it did; null blanks a field
+	patched, err := applyPatch(user, patch)
+	if err != nil {
+		return err
+	}
+	patched.ETag = resp.Header.Get("ETag")
+	*user = *patched
+
+	return nil
+}
+
+// applyPatch returns user with the merge patch applied
+func applyPatch(user *User, patch map[string]any) (*User, error) {
+	body, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]any{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range patch {
+		fields[name] = value
+	}
+
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// null leaves a field of a new User at its zero value
+	var patched User
+	if err := json.Unmarshal(body, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// DeleteUser deletes the user with id; RestoreUser undoes that until the user
+// is purged.
+func (c *Client) DeleteUser(ctx context.Context, id int) error {
+	resp, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/users/%d", id), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// RestoreUser restores the deleted user with id; admin only.
+func (c *Client) RestoreUser(ctx context.Context, id int) error {
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/users/%d/restore", id), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user with id, and revokes the
+// user's API keys; admin only.
+func (c *Client) ResetPassword(ctx context.Context, id int, password string) error {
+	resp, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/users/%d/password", id), map[string]string{"password": password}, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// ImportRow is the outcome of one row of an import: created, skipped or
+// failed, with the reason and, for invalid rows, the messages for each field.
+type ImportRow struct {
+	Row    int                 `json:"row"`
+	Email  string              `json:"email"`
+	Status string              `json:"status"`
+	ID     int                 `json:"id,omitempty"`
+	Reason string              `json:"reason,omitempty"`
+	Errors map[string][]string `json:"errors,omitempty"`
+}
+
+// ImportReport is what became of every row of an import.
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportUsers creates users in bulk, and reports on each of them; rows that
+// cannot be created are reported rather than failing the import. With dryRun,
+// nothing is created.
+func (c *Client) ImportUsers(ctx context.Context, users []NewUser, dryRun bool) (*ImportReport, error) {
+	path := "/users/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, path, users, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var report ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("apiclient: reading import report: %w", err)
+	}
+
+	return &report, nil
+}
+
+// ifMatch returns the If-Match header for etag, * when there is none
+func ifMatch(etag string) http.Header {
+	if etag == "" {
+		etag = "*"
+	}
+	return http.Header{"If-Match": []string{etag}}
+}
@@ -0,0 +1,89 @@
+// Package clientip finds the address of the client that sent a request. The
+// X-Forwarded-For header is only believed when it was added by a trusted
+// reverse proxy; anyone else could write any address in it.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver finds client addresses behind a set of trusted proxies. A nil
+// Resolver trusts no proxy.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New returns a Resolver that trusts the proxies at the given addresses or
+// CIDR ranges, e.g. 10.0.0.1 or 10.0.0.0/8.
+func New(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		cidr := proxy
+		if !strings.Contains(proxy, "/") {
+			// a single address is a network of one
+			cidr += "/128"
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				cidr = proxy + "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: not an IP address or CIDR range", proxy)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
+
+// IP returns the address req came from. When the peer is a trusted proxy, the
+// X-Forwarded-For entries are followed from the right, past the other trusted
+// proxies, to the first address that is not one. Only valid IPs are returned
+// from the header; the peer's address is returned as it is.
+func (r *Resolver) IP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	if !r.isTrusted(net.ParseIP(ip)) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop.String()
+		if !r.isTrusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// isTrusted reports whether ip is one of the trusted proxies
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package clientip_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"webApp/pkg/clientip"
+)
+
+func Test_IP(t *testing.T) {
+	resolver, err := clientip.New([]string{"10.0.0.1", "192.168.0.0/16", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer forwarding", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry before the proxy's", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.1, 192.168.3.4"}, "198.51.100.1"},
+		{"several headers", "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"192.168.3.4"}, "192.168.3.4"},
+		{"not an address", "10.0.0.1:1234", []string{"<script>"}, "10.0.0.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for _, value := range e.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+
+		if ip := resolver.IP(req); ip != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, ip)
+		}
+	}
+}
+
+func Test_IPWithoutProxies(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	var resolver *clientip.Resolver
+	if ip := resolver.IP(req); ip != "10.0.0.1" {
+		t.Errorf("expected a nil resolver to ignore X-Forwarded-For, but got %s", ip)
+	}
+}
+
+func Test_New(t *testing.T) {
+	if _, err := clientip.New([]string{"10.0.0.0/8", "::1"}); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	if _, err := clientip.New([]string{"proxy.example.com"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
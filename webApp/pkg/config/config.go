@@ -0,0 +1,475 @@
+// Package config loads the settings of the api, web and cli commands. Every
+// setting is taken from, in rising order of precedence, its default, a YAML
+// or TOML file, a WEBAPP_* environment variable and a command line flag. A
+// file whose name ends in .toml is read as TOML, any other as YAML; either way
+// it holds the settings at its top level, as keys and plain values or lists.
+//
+// A command describes its settings as a struct whose fields are tagged with
+// their name, which is also the flag's and the file's key:
+//
+//	DSN string `config:"dsn" secret:"dsn" usage:"database connection"`
+//
+// Fields without a tag that are structs are searched for more settings. A
+// secret tag keeps the value out of printed configurations, and in production
+// refuses the default, which is only fit for development; "dsn" hides just the
+// password of a database connection. A required tag refuses an empty value.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"webApp/pkg/clientip"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of the environment variable of every setting, so
+// -jwt-secret is read from WEBAPP_JWT_SECRET.
+const EnvPrefix = "WEBAPP_"
+
+// The environments a command runs in.
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// DefaultDSN connects to the Postgres of the development setup.
+const DefaultDSN = "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5"
+
+// redacted replaces secrets in printed configurations
+const redacted = "********"
+
+// ErrPrinted is returned by Load when -print-config asked for the
+// configuration to be printed rather than used.
+var ErrPrinted = errors.New("configuration printed")
+
+// Common are the settings of every command.
+type Common struct {
+	Env string `config:"env" usage:"environment: development|production; production refuses insecure defaults"`
+}
+
+// Database are the settings of the commands that connect to the database.
+type Database struct {
+	DSN     string        `config:"dsn" secret:"dsn" usage:"database connection: a Postgres DSN, or sqlite:<file> for SQLite"`
+	Timeout time.Duration `config:"db-timeout" usage:"how long a database query may take"`
+	Migrate bool          `config:"migrate" usage:"apply database migrations on startup"`
+}
+
+// Passwords choose how new passwords are hashed.
+type Passwords struct {
+	Hash       string `config:"password-hash" usage:"password hashing algorithm: bcrypt|argon2id"`
+	BcryptCost int    `config:"bcrypt-cost" usage:"bcrypt cost for new password hashes"`
+}
+
+// OIDC configures single sign-on with an OpenID Connect provider.
+type OIDC struct {
+	IssuerURL    string `config:"oidc-issuer" usage:"OpenID Connect issuer URL; single sign-on is disabled when empty"`
+	ClientID     string `config:"oidc-client-id" usage:"OpenID Connect client id"`
+	ClientSecret string `config:"oidc-client-secret" secret:"true" usage:"OpenID Connect client secret"`
+	RedirectURL  string `config:"oidc-redirect-url" usage:"OpenID Connect redirect URL"`
+}
+
+// Validate requires the client of an enabled provider.
+func (o *OIDC) Validate() error {
+	if o.IssuerURL != "" && (o.ClientID == "" || o.ClientSecret == "") {
+		return errors.New("oidc-client-id and oidc-client-secret are required with oidc-issuer")
+	}
+	return nil
+}
+
+// Proxies are the reverse proxies in front of a command.
+type Proxies struct {
+	Trusted []string `config:"trusted-proxies" usage:"addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For header is believed"`
+}
+
+// Validate requires every trusted proxy to be an address or a CIDR range.
+func (p *Proxies) Validate() error {
+	_, err := clientip.New(p.Trusted)
+	return err
+}
+
+// setting is one tagged field of a configuration
+type setting struct {
+	name     string
+	usage    string
+	secret   string
+	required bool
+	value    reflect.Value
+	// initial is the default, as the field held it before loading
+	initial string
+}
+
+// Load fills cfg, a pointer to a struct of tagged fields holding the defaults,
+// from the configuration file, the environment and args, and validates it.
+// Flags are added to fs for every setting, besides -config, which names the
+// file (or WEBAPP_CONFIG), and -print-config; commands may add their own
+// before. If cfg, or a struct in it, has a Validate method, it is called once
+// everything is loaded.
+func Load(fs *flag.FlagSet, cfg any, args []string) error {
+	settings, err := settingsOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	path := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML or TOML (*.toml) file to read settings from; environment variables and flags override it")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, secrets redacted, and exit")
+
+	flagged := map[string]string{}
+	for i := range settings {
+		fs.Var(&flagValue{setting: &settings[i], flagged: flagged}, settings[i].name, settings[i].usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *path != "" {
+		if err := readFile(*path, settings); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(EnvName(s.name))
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(s.name), err)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flagged[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("-%s: %w", s.name, err)
+		}
+	}
+
+	if err := validate(settings); err != nil {
+		return err
+	}
+	if err := validateStructs(reflect.ValueOf(cfg)); err != nil {
+		return err
+	}
+
+	if *printConfig {
+		Print(fs.Output(), cfg)
+		return ErrPrinted
+	}
+
+	return nil
+}
+
+// EnvName is the environment variable a setting is read from.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Print writes the settings of cfg one per line, as name: value, with the
+// secrets redacted.
+func Print(w io.Writer, cfg any) {
+	settings, err := settingsOf(cfg)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	for _, s := range settings {
+		fmt.Fprintf(w, "%s: %s\n", s.name, s.redacted(format(s.value)))
+	}
+}
+
+// String returns what Print writes, for logging.
+func String(cfg any) string {
+	var b strings.Builder
+	Print(&b, cfg)
+	return b.String()
+}
+
+// validate refuses empty required settings, unknown environments and, in
+// production, secrets left at their defaults
+func validate(settings []setting) error {
+	env := Development
+	for _, s := range settings {
+		if s.name == "env" {
+			env = format(s.value)
+		}
+	}
+	if env != Development && env != Production {
+		return fmt.Errorf("env must be %s or %s, not %q", Development, Production, env)
+	}
+
+	var problems []string
+	for _, s := range settings {
+		value := format(s.value)
+		switch {
+		case s.required && value == "":
+			problems = append(problems, s.name+" is required")
+		case env == Production && s.secret != "" && s.initial != "" && value == s.initial:
+			problems = append(problems, s.name+" must not be left at its development default in production")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateStructs calls the Validate methods of v and the structs in it
+func validateStructs(v reflect.Value) error {
+	if validator, ok := v.Interface().(interface{ Validate() error }); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+
+	elem := v.Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Field(i)
+		if field.Kind() == reflect.Struct && field.CanAddr() && elem.Type().Field(i).IsExported() {
+			if err := validateStructs(field.Addr()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readFile sets the settings named in a YAML or TOML file, refusing the names
+// of settings there are not, which are likely misspelt
+func readFile(path string, settings []setting) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]any
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		unmarshal = toml.Unmarshal
+	}
+	if err := unmarshal(content, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	byName := map[string]*setting{}
+	for i := range settings {
+		byName[settings[i].name] = &settings[i]
+	}
+
+	for name, value := range values {
+		s, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+
+		var text string
+		switch value := value.(type) {
+		case nil:
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			text = strings.Join(items, ",")
+		case map[any]any, map[string]any:
+			return fmt.Errorf("%s: %s must not be a mapping", path, name)
+		default:
+			text = fmt.Sprint(value)
+		}
+
+		if err := s.set(text); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// settingsOf lists the tagged fields of the struct cfg points to
+func settingsOf(cfg any) ([]setting, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+
+	var settings []setting
+	if err := collect(v.Elem(), &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func collect(v reflect.Value, settings *[]setting) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Tag.Get("config")
+		if name == "" {
+			if field.Type.Kind() == reflect.Struct {
+				if err := collect(v.Field(i), settings); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !settable(field.Type) {
+			return fmt.Errorf("config: setting %s has unsupported type %s", name, field.Type)
+		}
+
+		*settings = append(*settings, setting{
+			name:     name,
+			usage:    field.Tag.Get("usage"),
+			secret:   field.Tag.Get("secret"),
+			required: field.Tag.Get("required") == "true",
+			value:    v.Field(i),
+			initial:  format(v.Field(i)),
+		})
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func settable(t reflect.Type) bool {
+	switch {
+	case t == durationType:
+		return true
+	case t.Kind() == reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// format writes a setting's value the way set reads it
+func format(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// set parses value into the setting; lists are comma separated
+func (s *setting) set(value string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+		return nil
+	case s.value.Kind() == reflect.Slice:
+		s.value.Set(reflect.ValueOf(SplitList(value)))
+		return nil
+	}
+
+	switch s.value.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		s.value.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		s.value.SetBool(b)
+	default:
+		s.value.SetString(value)
+	}
+	return nil
+}
+
+// dsnPassword finds the password of a key=value DSN
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redacted hides value if the setting is secret
+func (s *setting) redacted(value string) string {
+	switch {
+	case s.secret == "" || value == "":
+		return value
+	case s.secret == "dsn":
+		if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				// Redacted would write xxxxx
+				return strings.Replace(u.Redacted(), ":xxxxx@", ":"+redacted+"@", 1)
+			}
+			return value
+		}
+		return dsnPassword.ReplaceAllString(value, "${1}"+redacted)
+	}
+	return redacted
+}
+
+// SplitList splits a comma separated value, dropping blanks.
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// flagValue records a flag, to be set once the file and the environment have
+// been read, so the flag wins over them
+type flagValue struct {
+	setting *setting
+	flagged map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.setting == nil {
+		return ""
+	}
+	return f.setting.redacted(f.setting.initial)
+}
+
+func (f *flagValue) Set(value string) error {
+	// check it now, so the flag package reports it with the usage
+	probe := *f.setting
+	probe.value = reflect.New(f.setting.value.Type()).Elem()
+	if err := probe.set(value); err != nil {
+		return err
+	}
+
+	f.flagged[f.setting.name] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting != nil && f.setting.value.Kind() == reflect.Bool
+}
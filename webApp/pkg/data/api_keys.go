@@ -0,0 +1,82 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be granted.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// APIKeyScopes lists every scope that can be granted to an API key.
+var APIKeyScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+// apiKeyPrefix makes keys easy to recognise, e.g. by secret scanners
+const apiKeyPrefix = "wak_"
+
+// APIKey is the type for long lived keys used by machine clients. Only the
+// hash of a key is stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key has been granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked reports whether the key has been revoked.
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// GenerateAPIKey returns a new random key, the prefix we show in listings to
+// identify it, and the hash we store.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	prefix = key[:len(apiKeyPrefix)+8]
+
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the value we store for key. Keys are random and high
+// entropy, so a fast hash is enough and lets us look keys up by hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidScope reports whether scope can be granted to an API key.
+func ValidScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_GenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, prefix) || !strings.HasPrefix(prefix, "wak_") {
+		t.Errorf("unexpected key %q or prefix %q", key, prefix)
+	}
+
+	if hash != HashAPIKey(key) {
+		t.Error("returned hash does not match HashAPIKey")
+	}
+
+	other, _, _, _ := GenerateAPIKey()
+	if other == key {
+		t.Error("generated the same key twice")
+	}
+}
+
+func TestAPIKey_HasScope(t *testing.T) {
+	key := APIKey{Scopes: []string{ScopeUsersRead}}
+
+	if !key.HasScope(ScopeUsersRead) {
+		t.Error("expected key to have read scope")
+	}
+
+	if key.HasScope(ScopeUsersWrite) {
+		t.Error("did not expect key to have write scope")
+	}
+}
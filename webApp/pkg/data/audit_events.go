@@ -0,0 +1,40 @@
+package data
+
+import "time"
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin              = "auth.login"
+	AuditLoginFailed        = "auth.login_failed"
+	AuditUserCreated        = "user.created"
+	AuditUserUpdated        = "user.updated"
+	AuditUserDeleted        = "user.deleted"
+	AuditUserRestored       = "user.restored"
+	AuditUserPurged         = "user.purged"
+	AuditAdminChanged       = "user.admin_changed"
+	AuditPasswordReset      = "user.password_reset"
+	AuditProfilePicUploaded = "user.profile_pic_uploaded"
+)
+
+// AuditEvent is one entry in the audit log. ActorID is the user who did
+// something, TargetUserID the user it was done to; either is 0 when unknown,
+// e.g. the actor of a failed login.
+type AuditEvent struct {
+	ID           int            `json:"id"`
+	ActorID      int            `json:"actor_id,omitempty"`
+	Action       string         `json:"action"`
+	TargetUserID int            `json:"target_user_id,omitempty"`
+	IP           string         `json:"ip"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// AuditFilter selects audit events; zero values match everything.
+type AuditFilter struct {
+	ActorID      int
+	TargetUserID int
+	Action       string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
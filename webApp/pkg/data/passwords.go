@@ -0,0 +1,171 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the cost we used before hashing became configurable.
+const DefaultBcryptCost = 12
+
+// ErrUnknownHash is returned when a stored hash is in a format we do not know.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords, and tells us whether an existing hash was
+// made with different parameters than the ones currently configured.
+type PasswordHasher interface {
+	Hash(plainText string) (string, error)
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher returns the hasher for algorithm, which is either
+// "bcrypt" or "argon2id".
+func NewPasswordHasher(algorithm string, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case "", "bcrypt":
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return BcryptHasher{Cost: bcryptCost}, nil
+	case "argon2id":
+		return DefaultArgon2idHasher, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// BcryptHasher hashes passwords with bcrypt at a given cost.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of plainText.
+func (h BcryptHasher) Hash(plainText string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainText), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NeedsRehash reports whether hash is not a bcrypt hash at our cost.
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// DefaultArgon2idHasher uses the parameters recommended by RFC 9106 for
+// memory constrained environments.
+var DefaultArgon2idHasher = Argon2idHasher{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+// Hash returns plainText hashed with argon2id, in the PHC string format
+// $argon2id$v=19$m=...,t=...,p=...$salt$hash.
+func (h Argon2idHasher) Hash(plainText string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plainText), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// NeedsRehash reports whether hash is not an argon2id hash with our parameters.
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time != h.Time ||
+		params.Memory != h.Memory ||
+		params.Threads != h.Threads ||
+		uint32(len(key)) != h.KeyLen ||
+		uint32(len(salt)) != h.SaltLen
+}
+
+// CheckPassword compares plainText with a stored bcrypt or argon2id hash.
+// A mismatch is reported as false with a nil error.
+func CheckPassword(hash, plainText string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(plainText), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainText))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			// invalid password
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func decodeArgon2id(hash string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+// cheap parameters, so the tests stay fast
+var testArgon2id = Argon2idHasher{Time: 1, Memory: 8 * 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+func Test_NewPasswordHasher(t *testing.T) {
+	var tests = []struct {
+		name          string
+		algorithm     string
+		cost          int
+		errorExpected bool
+	}{
+		{"default", "", DefaultBcryptCost, false},
+		{"bcrypt", "bcrypt", 10, false},
+		{"bcrypt cost too low", "bcrypt", 1, true},
+		{"bcrypt cost too high", "bcrypt", 40, true},
+		{"argon2id", "argon2id", 0, false},
+		{"unknown", "md5", 0, true},
+	}
+
+	for _, e := range tests {
+		_, err := NewPasswordHasher(e.algorithm, e.cost)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+	}
+}
+
+func Test_CheckPassword(t *testing.T) {
+	bcryptHash, _ := BcryptHasher{Cost: 4}.Hash("secret")
+	argonHash, _ := testArgon2id.Hash("secret")
+
+	var tests = []struct {
+		name          string
+		hash          string
+		password      string
+		expected      bool
+		errorExpected bool
+	}{
+		{"bcrypt match", bcryptHash, "secret", true, false},
+		{"bcrypt mismatch", bcryptHash, "wrong", false, false},
+		{"argon2id match", argonHash, "secret", true, false},
+		{"argon2id mismatch", argonHash, "wrong", false, false},
+		{"broken argon2id", "$argon2id$v=19$m=1$x$y", "secret", false, true},
+		{"garbage", "not a hash", "secret", false, true},
+	}
+
+	for _, e := range tests {
+		matches, err := CheckPassword(e.hash, e.password)
+		if matches != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.name, e.expected, matches)
+		}
+		if (err != nil) != e.errorExpected {
+			t.Errorf("%s: unexpected error state: %v", e.name, err)
+		}
+	}
+}
+
+func Test_NeedsRehash(t *testing.T) {
+	lowCost, _ := BcryptHasher{Cost: 4}.Hash("secret")
+	argonHash, _ := testArgon2id.Hash("secret")
+
+	stronger := testArgon2id
+	stronger.Time = 2
+
+	var tests = []struct {
+		name     string
+		hasher   PasswordHasher
+		hash     string
+		expected bool
+	}{
+		{"same bcrypt cost", BcryptHasher{Cost: 4}, lowCost, false},
+		{"different bcrypt cost", BcryptHasher{Cost: 5}, lowCost, true},
+		{"argon2id hash with bcrypt", BcryptHasher{Cost: 4}, argonHash, true},
+		{"same argon2id parameters", testArgon2id, argonHash, false},
+		{"different argon2id parameters", stronger, argonHash, true},
+		{"bcrypt hash with argon2id", testArgon2id, lowCost, true},
+	}
+
+	for _, e := range tests {
+		if e.hasher.NeedsRehash(e.hash) != e.expected {
+			t.Errorf("%s: expected %t", e.name, e.expected)
+		}
+	}
+
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Errorf("unexpected argon2id encoding: %s", argonHash)
+	}
+}
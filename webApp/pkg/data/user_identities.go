@@ -0,0 +1,14 @@
+package data
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider. Provider is the issuer URL, Subject the "sub" claim.
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"-"`
+}
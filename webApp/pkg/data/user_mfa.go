@@ -0,0 +1,22 @@
+package data
+
+import "time"
+
+// UserMFA is the type for a user's TOTP two-factor authentication settings.
+// A row with Enabled set to false is an enrollment that has not been
+// confirmed with a valid code yet.
+type UserMFA struct {
+	UserID        int       `json:"user_id"`
+	Secret        string    `json:"-"`
+	Enabled       bool      `json:"enabled"`
+	RecoveryCodes []string  `json:"-"`
+	// LastUsedStep is the TOTP time step of the last code accepted; codes of
+	// that step or earlier are refused, so a code works only once
+	LastUsedStep int64 `json:"-"`
+	// FailedAttempts counts the codes tried since the last one accepted, and
+	// once they reach the limit, LockedUntil is when the next try is allowed
+	FailedAttempts int        `json:"-"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"-"`
+	UpdatedAt      time.Time  `json:"-"`
+}
@@ -1,11 +1,6 @@
 package data
 
-import (
-	"errors"
-	"time"
-
-	"golang.org/x/crypto/bcrypt"
-)
+import "time"
 
 // User describes the data for the User type.
 type User struct {
@@ -18,22 +13,14 @@ type User struct {
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 	ProfilePic UserImage `json:"-"`
+	// Version goes up by one with every update; see UpdateUser
+	Version int `json:"-"`
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
+// PasswordMatches compares a user supplied password with the hash we have
+// stored for a given user in the database. Both bcrypt and argon2id hashes are
+// understood. If the password and hash match, we return true; otherwise, we
+// return false.
 func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-
-	return true, nil
+	return CheckPassword(u.Password, plainText)
 }
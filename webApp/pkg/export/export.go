@@ -0,0 +1,112 @@
+// Package export writes user lists in the formats admins download them in,
+// CSV and newline delimited JSON, streaming them from the database a user at
+// a time.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// Media types of the formats Users writes
+const (
+	CSV    = "text/csv"
+	NDJSON = "application/x-ndjson"
+)
+
+// flushEvery is how many users are written between flushes to the client
+const flushEvery = 100
+
+// csvHeader names the CSV columns; they match the JSON fields of data.User
+var csvHeader = []string{"id", "first_name", "last_name", "email", "is_admin"}
+
+// ContentType returns the Content-Type header for format
+func ContentType(format string) string {
+	if format == CSV {
+		return CSV + "; charset=utf-8"
+	}
+	return format
+}
+
+// FileName returns the name to save an export in format as
+func FileName(format string) string {
+	if format == CSV {
+		return "users.csv"
+	}
+	return "users.ndjson"
+}
+
+// Users writes every user in repo to w in format, CSV or NDJSON, in the
+// order of AllUsers. When w is an http.Flusher, what has been written is sent
+// every flushEvery users, so that the client sees the download progress.
+func Users(ctx context.Context, w io.Writer, format string, repo repository.DatabaseRepo) error {
+	var write func(u *data.User) error
+	var flush func() error
+
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(u *data.User) error {
+			return cw.Write([]string{strconv.Itoa(u.ID), csvCell(u.FirstName), csvCell(u.LastName), csvCell(u.Email), strconv.Itoa(u.IsAdmin)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case NDJSON:
+		enc := json.NewEncoder(w)
+		write = func(u *data.User) error {
+			return enc.Encode(u)
+		}
+		flush = func() error {
+			return nil
+		}
+	default:
+		return fmt.Errorf("export: unknown format %q", format)
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	written := 0
+	err := repo.EachUser(ctx, func(u *data.User) error {
+		if err := write(u); err != nil {
+			return err
+		}
+
+		written++
+		if written%flushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// csvCell keeps a value from being read as a formula by a spreadsheet, by
+// quoting values that start like one with a '
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package export_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/export"
+	"webApp/pkg/repository/dbrepo"
+)
+
+func Test_UsersCSV(t *testing.T) {
+	repo := dbrepo.NewTestDBRepo()
+	_, _ = repo.InsertUser(context.Background(), data.User{FirstName: "=cmd", LastName: "Zimmer, Jr.", Email: "zimmer@example.com", Password: "secret"})
+
+	var out strings.Builder
+	if err := export.Users(context.Background(), &out, export.CSV, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal("export is not valid CSV:", err)
+	}
+
+	expected := [][]string{
+		{"id", "first_name", "last_name", "email", "is_admin"},
+		{"1", "Admin", "User", "admin@example.com", "1"},
+		{"2", "MFA", "User", "mfa@example.com", "0"},
+		{"4", "'=cmd", "Zimmer, Jr.", "zimmer@example.com", "0"},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d rows, but got %d: %v", len(expected), len(records), records)
+	}
+
+	for i := range expected {
+		if strings.Join(records[i], "|") != strings.Join(expected[i], "|") {
+			t.Errorf("row %d: expected %v, but got %v", i, expected[i], records[i])
+		}
+	}
+}
+
+func Test_UsersNDJSON(t *testing.T) {
+	var out strings.Builder
+	if err := export.Users(context.Background(), &out, export.NDJSON, dbrepo.NewTestDBRepo()); err != nil {
+		t.Fatal(err)
+	}
+
+	var emails []string
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var u data.User
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			t.Fatalf("line %q is not a JSON user: %s", scanner.Text(), err)
+		}
+		emails = append(emails, u.Email)
+	}
+
+	if strings.Join(emails, ",") != "admin@example.com,mfa@example.com" {
+		t.Errorf("unexpected users %v", emails)
+	}
+
+	if strings.Contains(out.String(), "password") {
+		t.Error("the export contains passwords")
+	}
+}
+
+func Test_UsersUnknownFormat(t *testing.T) {
+	var out strings.Builder
+	if err := export.Users(context.Background(), &out, "text/html", dbrepo.NewTestDBRepo()); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,98 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Code(t *testing.T) {
+	// RFC 6238 appendix B test vectors (SHA1), truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		name     string
+		unix     int64
+		expected string
+	}{
+		{"59", 59, "287082"},
+		{"1111111109", 1111111109, "081804"},
+		{"1111111111", 1111111111, "050471"},
+		{"1234567890", 1234567890, "005924"},
+		{"2000000000", 2000000000, "279037"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(secret, time.Unix(e.unix, 0))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+
+		if code != e.expected {
+			t.Errorf("%s: expected code %s but got %s", e.name, e.expected, code)
+		}
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Now()
+	code, _ := Code(secret, now)
+	previous, _ := Code(secret, now.Add(-Period*time.Second))
+	old, _ := Code(secret, now.Add(-Period*3*time.Second))
+
+	var tests = []struct {
+		name     string
+		code     string
+		secret   string
+		expected bool
+	}{
+		{"current", code, secret, true},
+		{"previous period", previous, secret, true},
+		{"too old", old, secret, old == code},
+		{"wrong length", "12345", secret, false},
+		{"bad secret", code, "!!!", false},
+	}
+
+	for _, e := range tests {
+		if Validate(e.code, e.secret, now) != e.expected {
+			t.Errorf("%s: expected %t", e.name, e.expected)
+		}
+	}
+}
+
+func Test_ProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("example.com", "admin@example.com", "ABC")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/example.com:admin@example.com?") {
+		t.Errorf("unexpected uri prefix: %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("secret missing from uri: %s", uri)
+	}
+}
+
+func Test_GenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Errorf("expected %d codes but got %d", RecoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if seen[c] {
+			t.Errorf("duplicate recovery code %s", c)
+		}
+		seen[c] = true
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(codes[0])) {
+		t.Error("recovery code hash should ignore case and surrounding space")
+	}
+}
@@ -0,0 +1,64 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+	"webApp/pkg/data"
+)
+
+const (
+	// MaxAttempts is how many codes a user may try, without one being
+	// accepted, before they are locked out.
+	MaxAttempts = 5
+	// Lockout is how long a locked out user has to wait for another try.
+	Lockout = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidCode is returned by Verify for a wrong, expired or reused code.
+	ErrInvalidCode = errors.New("invalid authentication code")
+	// ErrLocked is returned by Verify while the user is locked out.
+	ErrLocked = errors.New("too many invalid authentication codes, try again later")
+)
+
+// Store is what Verify needs of the repository.
+type Store interface {
+	GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error)
+	BeginMFAAttempt(ctx context.Context, userID int, limit int, now, lockedUntil time.Time) (bool, error)
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+}
+
+// Verify checks code against the user's TOTP secret, and if that fails, tries
+// to consume it as a recovery code. Every attempt counts towards MaxAttempts,
+// whichever login flow makes it, and a TOTP code is accepted only once.
+func Verify(ctx context.Context, store Store, userID int, code string, now time.Time) error {
+	userMFA, err := store.GetUserMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := store.BeginMFAAttempt(ctx, userID, MaxAttempts, now, now.Add(Lockout))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrLocked
+	}
+
+	var used bool
+	if step, ok := ValidateStep(code, userMFA.Secret, now); ok {
+		used, err = store.UseTOTPStep(ctx, userID, step)
+	} else {
+		used, err = store.UseRecoveryCode(ctx, userID, HashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
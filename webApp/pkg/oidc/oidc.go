@@ -0,0 +1,335 @@
+// Package oidc implements the parts of OpenID Connect we need to let users
+// sign in with an external identity provider: discovery, the authorization
+// code flow with PKCE, and ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Config holds the settings for one identity provider.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to openid, email and profile
+	Scopes []string
+	// HTTPClient defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+}
+
+// keysRefetchInterval is how long after fetching the provider's keys a token
+// signed with a key we do not know makes us fetch them again. Anyone can send
+// such tokens, so they must not each cost a request to the provider.
+const keysRefetchInterval = time.Minute
+
+// Client talks to one identity provider.
+type Client struct {
+	config   Config
+	metadata providerMetadata
+
+	// fetchMu is held while the keys are fetched, mu while they are read or
+	// replaced, so that logins with a known key never wait on the provider
+	fetchMu   sync.Mutex
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// IDTokenClaims are the claims we read from an ID token.
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// NewClient fetches the provider's discovery document and returns a client for it.
+func NewClient(ctx context.Context, config Config) (*Client, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	c := &Client{config: config}
+
+	discoveryURL := strings.TrimSuffix(config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	err := c.getJSON(ctx, discoveryURL, &c.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if c.metadata.Issuer != config.IssuerURL {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", c.metadata.Issuer, config.IssuerURL)
+	}
+
+	return c, nil
+}
+
+// Issuer returns the provider's issuer URL, which we use to name linked identities.
+func (c *Client) Issuer() string {
+	return c.metadata.Issuer
+}
+
+// AuthCodeURL returns the URL to send the user to, to sign in at the provider.
+func (c *Client) AuthCodeURL(state, nonce, codeChallenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.config.ClientID)
+	v.Set("redirect_uri", c.config.RedirectURL)
+	v.Set("scope", strings.Join(c.config.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange trades an authorization code for tokens, and returns the verified
+// claims of the ID token.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDTokenClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange failed: %s %s", resp.Status, tokens.Error)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+
+	return c.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token, and returns its claims.
+func (c *Client) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return c.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(c.metadata.Issuer, true) {
+		return nil, errors.New("id token has wrong issuer")
+	}
+
+	if !claims.VerifyAudience(c.config.ClientID, true) {
+		return nil, errors.New("id token has wrong audience")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// publicKey returns the signing key with id kid. When we do not know it, the
+// provider's keys are fetched again, in case they were rotated, but no more
+// than once per keysRefetchInterval; the keys we have are kept until a new set
+// has been read.
+func (c *Client) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	// the keys may have been fetched while we waited
+	c.mu.Lock()
+	key, ok = c.keys[kid]
+	due := time.Since(c.fetchedAt) >= keysRefetchInterval
+	if !ok && due {
+		// counted whether it works or not, or a provider that is down
+		// would be asked on every login
+		c.fetchedAt = time.Now()
+	}
+	c.mu.Unlock()
+
+	switch {
+	case ok:
+		return key, nil
+	case !due:
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := c.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchKeys fetches and reads the provider's RSA signing keys, by id
+func (c *Client) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := c.getJSON(ctx, c.metadata.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", target, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// RandomString returns a random, URL safe string, for use as state and nonce.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 code challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, CodeChallenge(verifier), nil
+}
+
+// CodeChallenge returns the S256 code challenge for verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
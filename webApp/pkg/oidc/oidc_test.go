@@ -0,0 +1,277 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/oidc"
+	"webApp/pkg/oidc/oidctest"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/dbrepo"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const redirectURL = "http://localhost:8080/login/oidc/callback"
+
+func newTestClient(t *testing.T) (*oidc.Client, *oidctest.Provider) {
+	provider := oidctest.NewProvider()
+	t.Cleanup(provider.Close)
+
+	client, err := oidc.NewClient(context.Background(), provider.Config(redirectURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, provider
+}
+
+func Test_authorizationCodeFlow(t *testing.T) {
+	client, provider := newTestClient(t)
+
+	state, _ := oidc.RandomString()
+	nonce, _ := oidc.RandomString()
+	verifier, challenge, _ := oidc.NewPKCE()
+
+	callback, err := provider.Authorize(client.AuthCodeURL(state, nonce, challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if callback.Query().Get("state") != state {
+		t.Errorf("expected state %s but got %s", state, callback.Query().Get("state"))
+	}
+
+	code := callback.Query().Get("code")
+
+	// wrong verifier first: the provider must refuse, and the code is burned
+	_, err = client.Exchange(context.Background(), code, "wrong-verifier", nonce)
+	if err == nil {
+		t.Error("expected exchange with wrong code verifier to fail")
+	}
+
+	callback, _ = provider.Authorize(client.AuthCodeURL(state, nonce, challenge))
+	code = callback.Query().Get("code")
+
+	claims, err := client.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != provider.User.Subject || claims.Email != provider.User.Email || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// a code can only be used once
+	_, err = client.Exchange(context.Background(), code, verifier, nonce)
+	if err == nil {
+		t.Error("expected second exchange of the same code to fail")
+	}
+}
+
+func Test_VerifyIDToken(t *testing.T) {
+	client, provider := newTestClient(t)
+
+	valid := oidc.IDTokenClaims{
+		Nonce: "nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: provider.URL,
+			Subject: "subject",
+			Audience: jwt.ClaimStrings{provider.ClientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "https://evil.example.com"
+
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.ClaimStrings{"someone-else"}
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	noSubject := valid
+	noSubject.Subject = ""
+
+	var tests = []struct {
+		name          string
+		claims        oidc.IDTokenClaims
+		nonce         string
+		errorExpected bool
+	}{
+		{"valid", valid, "nonce", false},
+		{"wrong nonce", valid, "other", true},
+		{"empty nonce", valid, "", true},
+		{"wrong issuer", wrongIssuer, "nonce", true},
+		{"wrong audience", wrongAudience, "nonce", true},
+		{"expired", expired, "nonce", true},
+		{"no subject", noSubject, "nonce", true},
+	}
+
+	for _, e := range tests {
+		raw, _ := provider.IDToken(e.claims)
+
+		_, err := client.VerifyIDToken(context.Background(), raw, e.nonce)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+	}
+
+	// a token signed with a key the provider does not publish
+	other := oidctest.NewProvider()
+	defer other.Close()
+
+	raw, _ := other.IDToken(valid)
+	if _, err := client.VerifyIDToken(context.Background(), raw, "nonce"); err == nil {
+		t.Error("accepted a token signed by another key")
+	}
+}
+
+func Test_VerifyIDTokenUnknownKeys(t *testing.T) {
+	client, provider := newTestClient(t)
+
+	claims := oidc.IDTokenClaims{
+		Nonce: "nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: provider.URL,
+			Subject: "subject",
+			Audience: jwt.ClaimStrings{provider.ClientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+
+	raw, _ := provider.IDToken(claims)
+	if _, err := client.VerifyIDToken(context.Background(), raw, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens with made up key ids, which the provider's keys were just
+	// fetched for
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for i := 0; i < 5; i++ {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = fmt.Sprintf("made-up-%d", i)
+		forged, _ := token.SignedString(key)
+
+		if _, err := client.VerifyIDToken(context.Background(), forged, "nonce"); err == nil {
+			t.Errorf("accepted a token signed with unknown key %d", i)
+		}
+	}
+
+	if n := provider.JWKSRequests(); n != 1 {
+		t.Errorf("expected the keys to be fetched once, but they were fetched %d times", n)
+	}
+
+	// the keys we have are still used
+	if _, err := client.VerifyIDToken(context.Background(), raw, "nonce"); err != nil {
+		t.Error("known key no longer accepted:", err)
+	}
+}
+
+// failingLinkStore fails to link identities, inside the transaction that
+// provisions their users
+type failingLinkStore struct {
+	*dbrepo.MemoryDBRepo
+}
+
+func (s *failingLinkStore) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return s.MemoryDBRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		return fn(failingLinkRepo{repo})
+	})
+}
+
+type failingLinkRepo struct {
+	repository.DatabaseRepo
+}
+
+func (failingLinkRepo) InsertUserIdentity(ctx context.Context, i data.UserIdentity) (int, error) {
+	return 0, errLink
+}
+
+var errLink = errors.New("link failed")
+
+func Test_ResolveUser(t *testing.T) {
+	store := dbrepo.NewMemoryDBRepo(data.BcryptHasher{Cost: bcrypt.MinCost})
+	store.Seed(dbrepo.Fixtures{Users: []data.User{
+		{ID: 1, Email: "admin@example.com", IsAdmin: 1},
+		{ID: 2, Email: "user@example.com"},
+	}})
+
+	claims := func(subject, email string, verified bool) *oidc.IDTokenClaims {
+		return &oidc.IDTokenClaims{
+			Email: email,
+			EmailVerified: verified,
+			Name: "New Person",
+			RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+		}
+	}
+
+	var tests = []struct {
+		name           string
+		claims         *oidc.IDTokenClaims
+		expectedUserID int
+		expectedError  error
+	}{
+		{"link by verified email", claims("sub-1", "user@example.com", true), 2, nil},
+		{"linked identity, email changed", claims("sub-1", "changed@example.com", false), 2, nil},
+		{"unverified email", claims("sub-2", "user@example.com", false), 0, oidc.ErrEmailNotVerified},
+		{"admin not linked", claims("sub-2", "admin@example.com", true), 0, oidc.ErrAdminNotLinked},
+		{"new user provisioned", claims("sub-3", "new@example.com", true), 3, nil},
+		{"provisioned user signs in again", claims("sub-3", "new@example.com", true), 3, nil},
+	}
+
+	for _, e := range tests {
+		user, err := oidc.ResolveUser(context.Background(), store, "https://issuer.example.com", e.claims)
+		if !errors.Is(err, e.expectedError) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedError, err)
+			continue
+		}
+
+		if e.expectedUserID != 0 && user.ID != e.expectedUserID {
+			t.Errorf("%s: expected user %d but got %d", e.name, e.expectedUserID, user.ID)
+		}
+	}
+
+	for _, subject := range []string{"sub-1", "sub-3"} {
+		if _, err := store.GetUserIdentity(context.Background(), "https://issuer.example.com", subject); err != nil {
+			t.Errorf("identity %s was not linked: %v", subject, err)
+		}
+	}
+	if _, err := store.GetUserIdentity(context.Background(), "https://issuer.example.com", "sub-2"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("identity sub-2 should not be linked, got %v", err)
+	}
+
+	provisioned, _ := store.GetUser(context.Background(), 3)
+	if provisioned.FirstName != "New" || provisioned.LastName != "Person" {
+		t.Errorf("provisioned user has wrong name: %+v", provisioned)
+	}
+}
+
+func Test_ResolveUserRollsBack(t *testing.T) {
+	store := &failingLinkStore{MemoryDBRepo: dbrepo.NewMemoryDBRepo(data.BcryptHasher{Cost: bcrypt.MinCost})}
+
+	claims := &oidc.IDTokenClaims{
+		Email: "new@example.com",
+		EmailVerified: true,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-1"},
+	}
+
+	if _, err := oidc.ResolveUser(context.Background(), store, "https://issuer.example.com", claims); !errors.Is(err, errLink) {
+		t.Fatalf("expected %v but got %v", errLink, err)
+	}
+
+	// the user is only kept with their identity
+	if _, err := store.GetUserByEmail(context.Background(), "new@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected no user to be left behind, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+// Package oidctest provides an in-process OpenID Connect provider, so the
+// sign in flow can be tested without network access.
+//
+// The provider signs every user in without asking: its authorization endpoint
+// immediately redirects back with a code for Provider.User.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"webApp/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// User is the account the provider signs in as.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is a running mock identity provider.
+type Provider struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	User         User
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu           sync.Mutex
+	codes        map[string]authorization
+	jwksRequests int
+}
+
+type authorization struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          User
+}
+
+const keyID = "oidctest"
+
+// NewProvider starts a provider on a local port. Call Close when done.
+func NewProvider() *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		ClientID: "test-client",
+		ClientSecret: "test-secret",
+		User: User{
+			Subject: "oidc-subject-1",
+			Email: "admin@example.com",
+			EmailVerified: true,
+			GivenName: "Admin",
+			FamilyName: "User",
+		},
+		key: key,
+		codes: map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+
+	p.server = httptest.NewServer(mux)
+	p.URL = p.server.URL
+
+	return p
+}
+
+// Close shuts the provider down.
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// Config returns a client configuration for this provider.
+func (p *Provider) Config(redirectURL string) oidc.Config {
+	return oidc.Config{
+		IssuerURL: p.URL,
+		ClientID: p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL: redirectURL,
+		HTTPClient: p.server.Client(),
+	}
+}
+
+// Authorize follows an authorization URL the way a browser would, and returns
+// the URL the provider redirects back to, with code and state.
+func (p *Provider) Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Location()
+}
+
+// IDToken returns an ID token signed by the provider, for tests that need to
+// tamper with claims.
+func (p *Provider) IDToken(claims oidc.IDTokenClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(p.key)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer": p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint": p.URL + "/token",
+		"jwks_uri": p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "pkce required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := oidc.RandomString()
+
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		redirectURI: q.Get("redirect_uri"),
+		nonce: q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		user: p.User,
+	}
+	p.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.Form.Get("code")
+
+	// codes can only be used once
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("redirect_uri") != auth.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if oidc.CodeChallenge(r.Form.Get("code_verifier")) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := p.IDToken(oidc.IDTokenClaims{
+		Nonce: auth.nonce,
+		Email: auth.user.Email,
+		EmailVerified: auth.user.EmailVerified,
+		Name: auth.user.GivenName + " " + auth.user.FamilyName,
+		GivenName: auth.user.GivenName,
+		FamilyName: auth.user.FamilyName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: p.URL,
+			Subject: auth.user.Subject,
+			Audience: jwt.ClaimStrings{p.ClientID},
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, _ := oidc.RandomString()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": accessToken,
+		"id_token": idToken,
+		"token_type": "Bearer",
+	})
+}
+
+// JWKSRequests returns how often the provider's keys have been fetched.
+func (p *Provider) JWKSRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.jwksRequests
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.jwksRequests++
+	p.mu.Unlock()
+
+	pub := p.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{
+			{
+				"kid": keyID,
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
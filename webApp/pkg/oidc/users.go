@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// ErrEmailNotVerified is returned when a new identity can not be linked,
+// because the provider does not vouch for the user's email address.
+var ErrEmailNotVerified = errors.New("identity provider did not return a verified email address")
+
+// ErrAdminNotLinked is returned when a new identity's email address belongs to
+// an administrator. Taking over an admin account should need more than the
+// word of an identity provider, so such identities are never linked on their
+// own.
+var ErrAdminNotLinked = errors.New("identity provider email belongs to an administrator, and is not linked automatically")
+
+// UserStore is the part of the repository needed to link identities to users.
+// WithTx makes creating a user and linking their identity one unit of work.
+type UserStore interface {
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	GetUserIdentity(ctx context.Context, provider, subject string) (*data.UserIdentity, error)
+	InsertUserIdentity(ctx context.Context, i data.UserIdentity) (int, error)
+	WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error
+}
+
+// ResolveUser returns the user an identity belongs to. An identity we have not
+// seen before is linked to the user with the same, verified, email address,
+// unless that user is an administrator; if there is none, a new user is
+// created for it. A new user and their identity are stored together, or not at
+// all.
+func ResolveUser(ctx context.Context, store UserStore, issuer string, claims *IDTokenClaims) (*data.User, error) {
+	identity, err := store.GetUserIdentity(ctx, issuer, claims.Subject)
+	if err == nil {
+		return store.GetUser(ctx, identity.UserID)
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	var user *data.User
+	err = store.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		var err error
+		user, err = repo.GetUserByEmail(ctx, claims.Email)
+		switch {
+		case err == nil && user.IsAdmin == 1:
+			return ErrAdminNotLinked
+		case errors.Is(err, repository.ErrNotFound):
+			user, err = provisionUser(ctx, repo, claims)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.InsertUserIdentity(ctx, data.UserIdentity{
+			UserID: user.ID,
+			Provider: issuer,
+			Subject: claims.Subject,
+			Email: claims.Email,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// provisionUser creates a user for an identity. The user gets a random
+// password, so they can only sign in through the provider until it is reset.
+func provisionUser(ctx context.Context, store repository.DatabaseRepo, claims *IDTokenClaims) (*data.User, error) {
+	password, err := RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	user := data.User{
+		FirstName: firstName,
+		LastName: lastName,
+		Email: claims.Email,
+		Password: password,
+		IsAdmin: 0,
+	}
+
+	user.ID, err = store.InsertUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetUser(ctx, user.ID)
+}
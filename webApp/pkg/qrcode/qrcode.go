@@ -0,0 +1,540 @@
+// Package qrcode draws QR codes (ISO/IEC 18004) of text, so that pages can
+// show one without handing the text, such as a TOTP secret, to a script from
+// somewhere else. Text is encoded in byte mode, with error correction level M,
+// which is what authenticator apps are used to.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTooLong is returned for text that does not fit the largest QR code.
+var ErrTooLong = errors.New("qrcode: text too long")
+
+// QuietZone is the light border, in modules, SVG draws around a code.
+const QuietZone = 4
+
+// Code is a QR code of Size×Size modules.
+type Code struct {
+	Size    int
+	Version int
+	modules []bool
+}
+
+// Dark reports whether the module in column x of row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode returns the smallest QR code that holds text, with the mask that
+// makes it easiest to read.
+func Encode(text string) (*Code, error) {
+	return encode(text, -1)
+}
+
+// SVG draws the code, scale pixels per module, with its quiet zone.
+func (c *Code) SVG(scale int) string {
+	size := (c.Size + 2*QuietZone) * scale
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, c.Size+2*QuietZone, c.Size+2*QuietZone)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return b.String()
+}
+
+// blockLayout is how a version splits its codewords, at level M: blocks of
+// data codewords each followed by ecLen error correction codewords; the
+// long blocks come last and hold one data codeword more.
+type blockLayout struct {
+	ecLen, short, shortData, long int
+}
+
+var layouts = [41]blockLayout{
+	{},
+	{10, 1, 16, 0}, {16, 1, 28, 0}, {26, 1, 44, 0}, {18, 2, 32, 0}, {24, 2, 43, 0},
+	{16, 4, 27, 0}, {18, 4, 31, 0}, {22, 2, 38, 2}, {22, 3, 36, 2}, {26, 4, 43, 1},
+	{30, 1, 50, 4}, {22, 6, 36, 2}, {22, 8, 37, 1}, {24, 4, 40, 5}, {24, 5, 41, 5},
+	{28, 7, 45, 3}, {28, 10, 46, 1}, {26, 9, 43, 4}, {26, 3, 44, 11}, {26, 3, 41, 13},
+	{26, 17, 42, 0}, {28, 17, 46, 0}, {28, 4, 47, 14}, {28, 6, 45, 14}, {28, 8, 47, 13},
+	{28, 19, 46, 4}, {28, 22, 45, 3}, {28, 3, 45, 23}, {28, 21, 45, 7}, {28, 19, 47, 10},
+	{28, 2, 46, 29}, {28, 10, 46, 23}, {28, 14, 46, 21}, {28, 14, 46, 23}, {28, 12, 47, 26},
+	{28, 6, 47, 34}, {28, 29, 46, 14}, {28, 13, 46, 32}, {28, 40, 47, 7}, {28, 18, 47, 31},
+}
+
+func (l blockLayout) dataLen() int {
+	return l.short*l.shortData + l.long*(l.shortData+1)
+}
+
+// encode draws text with mask, or the best mask when it is -1
+func encode(text string, mask int) (*Code, error) {
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+countBits(v)+8*len(text) <= 8*layouts[v].dataLen() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := interleave(version, dataCodewords(version, text))
+
+	best := (*Code)(nil)
+	bestPenalty := 0
+	for m := 0; m < 8; m++ {
+		if mask >= 0 && m != mask {
+			continue
+		}
+
+		c := newCode(version)
+		c.drawCodewords(codewords)
+		c.applyMask(m)
+		c.drawFormat(m)
+
+		if p := c.penalty(); best == nil || p < bestPenalty {
+			best, bestPenalty = &Code{Size: c.size, Version: version, modules: c.dark}, p
+		}
+	}
+
+	return best, nil
+}
+
+// countBits is the length of the character count in byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// dataCodewords encodes text in byte mode and pads it to the data capacity
+func dataCodewords(version int, text string) []byte {
+	capacity := layouts[version].dataLen()
+
+	var bits bitBuffer
+	bits.put(0b0100, 4)
+	bits.put(len(text), countBits(version))
+	for i := 0; i < len(text); i++ {
+		bits.put(int(text[i]), 8)
+	}
+
+	terminator := 8*capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.put(0, terminator)
+	bits.put(0, (8-len(bits)%8)%8)
+
+	data := bits.bytes()
+	for pad := byte(0xec); len(data) < capacity; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) put(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// interleave splits data into the version's blocks, adds their error
+// correction, and takes the codewords a column of blocks at a time
+func interleave(version int, data []byte) []byte {
+	layout := layouts[version]
+	divisor := generator(layout.ecLen)
+
+	var blocks, ecc [][]byte
+	for i := 0; i < layout.short+layout.long; i++ {
+		n := layout.shortData
+		if i >= layout.short {
+			n++
+		}
+		blocks = append(blocks, data[:n])
+		ecc = append(ecc, remainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i <= layout.shortData; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecLen; i++ {
+		for _, e := range ecc {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z = z<<1 ^ carry*0x1d
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// generator returns the Reed-Solomon generator polynomial of degree n, its
+// leading coefficient left out
+func generator(n int) []byte {
+	g := make([]byte, n)
+	g[n-1] = 1
+
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			g[j] = gfMul(g[j], root)
+			if j+1 < n {
+				g[j] ^= g[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return g
+}
+
+// remainder returns the error correction codewords of data
+func remainder(data, divisor []byte) []byte {
+	r := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i, coef := range divisor {
+			r[i] ^= gfMul(coef, factor)
+		}
+	}
+	return r
+}
+
+// matrix is a code being drawn; reserved marks the function modules, which
+// the data and the mask leave alone
+type matrix struct {
+	size     int
+	dark     []bool
+	reserved []bool
+}
+
+func newCode(version int) *matrix {
+	size := 4*version + 17
+	c := &matrix{size: size, dark: make([]bool, size*size), reserved: make([]bool, size*size)}
+
+	// timing patterns
+	for i := 0; i < size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	// finder patterns, with their separators
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// alignment patterns, except where they would cover a finder
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, px := range positions {
+		for j, py := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(px+dx, py+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// the format is drawn once the mask is known; reserve its modules now
+	c.drawFormat(0)
+
+	if version >= 7 {
+		bits := version<<12 | bch(version, 0x1f25, 12)
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			c.set(a, b, bits>>i&1 == 1)
+			c.set(b, a, bits>>i&1 == 1)
+		}
+	}
+
+	return c
+}
+
+// alignmentPositions are the rows and columns alignment patterns are centred on
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	n := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	}
+
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, 4*version+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// bch returns the n check bits of value for the code of generator poly
+func bch(value, poly, n int) int {
+	r := value << n
+	for bit := n + bitLen(value); bit > n; bit-- {
+		if r>>(bit-1)&1 == 1 {
+			r ^= poly << (bit - 1 - n)
+		}
+	}
+	return r
+}
+
+func bitLen(v int) int {
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// drawFormat draws level M and mask, both copies
+func (c *matrix) drawFormat(mask int) {
+	data := 0b00<<3 | mask
+	bits := (data<<10 | bch(data, 0x537, 10)) ^ 0x5412
+
+	for i := 0; i < 15; i++ {
+		dark := bits>>i&1 == 1
+
+		// around the top left finder
+		switch {
+		case i < 6:
+			c.set(8, i, dark)
+		case i < 8:
+			c.set(8, i+1, dark)
+		case i == 8:
+			c.set(7, 8, dark)
+		default:
+			c.set(14-i, 8, dark)
+		}
+
+		// split between the other two
+		if i < 8 {
+			c.set(c.size-1-i, 8, dark)
+		} else {
+			c.set(8, c.size-15+i, dark)
+		}
+	}
+
+	c.set(8, c.size-8, true)
+}
+
+func (c *matrix) set(x, y int, dark bool) {
+	c.dark[y*c.size+x] = dark
+	c.reserved[y*c.size+x] = true
+}
+
+// drawCodewords fills the modules left free, two columns at a time, going up
+// and down in turn from the bottom right corner
+func (c *matrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < c.size; vert++ {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.reserved[y*c.size+x] {
+					continue
+				}
+				if i < len(codewords)*8 {
+					c.dark[y*c.size+x] = codewords[i/8]>>(7-i%8)&1 == 1
+				}
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules mask selects
+func (c *matrix) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.reserved[y*c.size+x] {
+				c.dark[y*c.size+x] = !c.dark[y*c.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, by the rules of the standard:
+// runs of one colour, 2×2 squares of one, patterns that look like a finder,
+// and a balance of dark and light away from half
+func (c *matrix) penalty() int {
+	p := 0
+
+	line := make([]bool, c.size)
+	for _, horizontal := range []bool{true, false} {
+		for a := 0; a < c.size; a++ {
+			for b := range line {
+				if horizontal {
+					line[b] = c.dark[a*c.size+b]
+				} else {
+					line[b] = c.dark[b*c.size+a]
+				}
+			}
+			p += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			d := c.dark[y*c.size+x]
+			if d {
+				dark++
+			}
+			if x+1 < c.size && y+1 < c.size &&
+				d == c.dark[y*c.size+x+1] && d == c.dark[(y+1)*c.size+x] && d == c.dark[(y+1)*c.size+x+1] {
+				p += 3
+			}
+		}
+	}
+
+	// 10 for every full 5% the dark modules are away from half
+	total := c.size * c.size
+	if k := (abs(dark*20-total*10)+total-1)/total - 1; k > 0 {
+		p += k * 10
+	}
+
+	return p
+}
+
+// finder is the 1:1:3:1:1 pattern of a finder's middle
+var finder = []bool{true, false, true, true, true, false, true}
+
+// linePenalty scores the runs of a row or column, and its finder lookalikes:
+// the pattern with four light modules, or the edge, on either side
+func linePenalty(line []bool) int {
+	p := 0
+
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			p += run - 2
+		}
+		run = 1
+	}
+
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i+len(finder) <= len(line); i++ {
+		match := true
+		for j, d := range finder {
+			if line[i+j] != d {
+				match = false
+				break
+			}
+		}
+		if match && (light(i-4, i) || light(i+len(finder), i+len(finder)+4)) {
+			p += 40
+		}
+	}
+
+	return p
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,371 @@
+package qrcode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// modules renders a code one row per line, # for dark
+func modules(c *Code) string {
+	var b strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func Test_encode(t *testing.T) {
+	// hashes of the codes Kazuhiko Arase's reference implementation draws
+	var tests = []struct {
+		name     string
+		text     string
+		mask     int
+		version  int
+		expected string
+	}{
+		{"version 1", "hello", 0, 1, "b9d3297baffa237b5e58f1e0e18ccb15675db6e8c6af54c36a243ad746fbe521"},
+		{"provisioning uri", "otpauth://totp/webApp:admin@example.com?secret=JBSWY3DPEHPK3PXP&issuer=webApp", 3, 5, "c19bcb58aea83e864e39f5bb7d56f59b31aaccef4bf88e366e982968de2c123f"},
+		{"version information", strings.Repeat("a", 300), 5, 13, "0346ccf2bbc7975f28bbb08034c357ae1801e495f2301265c1f222a116fd9062"},
+	}
+
+	for _, e := range tests {
+		code, err := encode(e.text, e.mask)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", e.name, err)
+		}
+
+		if code.Version != e.version || code.Size != 4*e.version+17 {
+			t.Errorf("%s: expected version %d but got %d of size %d", e.name, e.version, code.Version, code.Size)
+		}
+
+		sum := sha256.Sum256([]byte(modules(code)))
+		if hex.EncodeToString(sum[:]) != e.expected {
+			t.Errorf("%s: unexpected modules\n%s", e.name, modules(code))
+		}
+	}
+}
+
+func Test_encodeDecodes(t *testing.T) {
+	texts := []string{
+		"hello",
+		"otpauth://totp/webApp:admin@example.com?secret=JBSWY3DPEHPK3PXP&issuer=webApp",
+		strings.Repeat("a", 300),
+	}
+
+	for _, text := range texts {
+		for mask := 0; mask < 8; mask++ {
+			code, err := encode(text, mask)
+			if err != nil {
+				t.Fatalf("%q: unexpected error %s", text, err)
+			}
+
+			decoded, err := decode(code)
+			if err != nil {
+				t.Errorf("%q with mask %d: %s\n%s", text, mask, err, modules(code))
+				continue
+			}
+			if decoded != text {
+				t.Errorf("%q with mask %d: decoded %q", text, mask, decoded)
+			}
+		}
+	}
+}
+
+// specVersions are the parts of ISO/IEC 18004's tables decode needs, for the
+// versions the tests draw, at level M: the rows and columns alignment patterns
+// are centred on, the data codewords of each block, the error correction
+// codewords per block, and the version information.
+var specVersions = map[int]struct {
+	alignment   []int
+	blocks      []int
+	ecLen       int
+	versionInfo int
+}{
+	1:  {nil, []int{16}, 10, 0},
+	5:  {[]int{6, 30}, []int{43, 43}, 24, 0},
+	13: {[]int{6, 34, 62}, []int{37, 37, 37, 37, 37, 37, 37, 37, 38}, 22, 0x0d847},
+}
+
+// specFormats are the format information of level M, by mask
+var specFormats = []int{
+	0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+	0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+}
+
+// decode reads a code back the way a scanner would, from its modules alone,
+// so that it does not share the encoder's mistakes: it finds the mask in the
+// format information, unmasks and reads the codewords, checks each block's
+// error correction, and returns the byte mode text.
+func decode(c *Code) (string, error) {
+	version := (c.Size - 17) / 4
+	spec, ok := specVersions[version]
+	if !ok || c.Size != 4*version+17 {
+		return "", fmt.Errorf("no spec table for size %d", c.Size)
+	}
+
+	bitsAt := func(coords [][2]int) int {
+		v := 0
+		for _, xy := range coords {
+			v <<= 1
+			if c.Dark(xy[0], xy[1]) {
+				v |= 1
+			}
+		}
+		return v
+	}
+
+	// both copies of the format information, most significant bit first
+	n := c.Size
+	var first, second [][2]int
+	for x := 0; x <= 8; x++ {
+		if x != 6 {
+			first = append(first, [2]int{x, 8})
+		}
+	}
+	for y := 7; y >= 0; y-- {
+		if y != 6 {
+			first = append(first, [2]int{8, y})
+		}
+	}
+	for y := n - 1; y >= n-7; y-- {
+		second = append(second, [2]int{8, y})
+	}
+	for x := n - 8; x < n; x++ {
+		second = append(second, [2]int{x, 8})
+	}
+
+	mask := -1
+	for m, format := range specFormats {
+		if bitsAt(first) == format && bitsAt(second) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		return "", fmt.Errorf("format information %015b and %015b is not level M", bitsAt(first), bitsAt(second))
+	}
+
+	if !c.Dark(8, n-8) {
+		return "", errors.New("the dark module is light")
+	}
+
+	if version >= 7 {
+		var bottomLeft, topRight [][2]int
+		for i := 17; i >= 0; i-- {
+			bottomLeft = append(bottomLeft, [2]int{i / 3, n - 11 + i%3})
+			topRight = append(topRight, [2]int{n - 11 + i%3, i / 3})
+		}
+		if bitsAt(bottomLeft) != spec.versionInfo || bitsAt(topRight) != spec.versionInfo {
+			return "", fmt.Errorf("version information %018b and %018b is not version %d", bitsAt(bottomLeft), bitsAt(topRight), version)
+		}
+	}
+
+	isFunction := func(x, y int) bool {
+		switch {
+		case x < 9 && y < 9, x >= n-8 && y < 9, x < 9 && y >= n-8:
+			// finders, separators and format information
+			return true
+		case x == 6 || y == 6:
+			return true
+		case version >= 7 && (x >= n-11 && x < n-8 && y < 6 || y >= n-11 && y < n-8 && x < 6):
+			return true
+		}
+		for _, cx := range spec.alignment {
+			for _, cy := range spec.alignment {
+				if cx <= 8 && cy <= 8 || cx <= 8 && cy >= n-9 || cx >= n-9 && cy <= 8 {
+					continue
+				}
+				if x >= cx-2 && x <= cx+2 && y >= cy-2 && y <= cy+2 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	masks := []func(x, y int) bool{
+		func(x, y int) bool { return (y+x)%2 == 0 },
+		func(x, y int) bool { return y%2 == 0 },
+		func(x, y int) bool { return x%3 == 0 },
+		func(x, y int) bool { return (y+x)%3 == 0 },
+		func(x, y int) bool { return (y/2+x/3)%2 == 0 },
+		func(x, y int) bool { return (y*x)%2+(y*x)%3 == 0 },
+		func(x, y int) bool { return ((y*x)%2+(y*x)%3)%2 == 0 },
+		func(x, y int) bool { return ((y+x)%2+(y*x)%3)%2 == 0 },
+	}
+
+	// the codewords zigzag up and down pairs of columns from the right
+	var raw []byte
+	var bit, count int
+	up := true
+	for right := n - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < n; i++ {
+			y := i
+			if up {
+				y = n - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if isFunction(x, y) {
+					continue
+				}
+				bit <<= 1
+				if c.Dark(x, y) != masks[mask](x, y) {
+					bit |= 1
+				}
+				if count++; count%8 == 0 {
+					raw = append(raw, byte(bit))
+					bit = 0
+				}
+			}
+		}
+		up = !up
+	}
+
+	// undo the interleaving: the data codewords of every block, then their
+	// error correction codewords
+	blocks := make([][]byte, len(spec.blocks))
+	next := 0
+	for i := 0; i < spec.blocks[len(spec.blocks)-1]; i++ {
+		for b, dataLen := range spec.blocks {
+			if i < dataLen {
+				blocks[b] = append(blocks[b], raw[next])
+				next++
+			}
+		}
+	}
+	for i := 0; i < spec.ecLen; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], raw[next])
+			next++
+		}
+	}
+
+	var data []byte
+	for b, block := range blocks {
+		if !validBlock(block, spec.ecLen) {
+			return "", fmt.Errorf("block %d fails its error correction", b)
+		}
+		data = append(data, block[:spec.blocks[b]]...)
+	}
+
+	// byte mode: 0100, the length, the bytes, a terminator and padding
+	read := func(from, n int) int {
+		v := 0
+		for i := from; i < from+n; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+
+	if read(0, 4) != 0b0100 {
+		return "", fmt.Errorf("mode %04b is not byte mode", read(0, 4))
+	}
+	lengthBits := 8
+	if version >= 10 {
+		lengthBits = 16
+	}
+	length := read(4, lengthBits)
+	start := 4 + lengthBits
+	if start+8*length > 8*len(data) {
+		return "", fmt.Errorf("length %d does not fit", length)
+	}
+
+	text := make([]byte, length)
+	for i := range text {
+		text[i] = byte(read(start+8*i, 8))
+	}
+
+	// the terminator is cut short when the data ends less than 4 bits early
+	end := start + 8*length
+	terminator := 8*len(data) - end
+	if terminator > 4 {
+		terminator = 4
+	}
+	if read(end, terminator) != 0 {
+		return "", errors.New("no terminator")
+	}
+	for i, pad := (end+4+7)/8, 0; i < len(data); i, pad = i+1, pad+1 {
+		if data[i] != []byte{0xec, 0x11}[pad%2] {
+			return "", fmt.Errorf("codeword %d is %#x, not padding", i, data[i])
+		}
+	}
+
+	return string(text), nil
+}
+
+// validBlock reports whether the Reed-Solomon syndromes of block are all 0:
+// QR codes' generator has the roots α^0 to α^(ecLen-1), in GF(256) modulo
+// x^8+x^4+x^3+x^2+1, so a codeword without errors has them too.
+func validBlock(block []byte, ecLen int) bool {
+	var exp [255]byte
+	x := 1
+	for i := range exp {
+		exp[i] = byte(x)
+		x <<= 1
+		if x > 0xff {
+			x ^= 0x11d
+		}
+	}
+	mul := func(a, b byte) byte {
+		var p byte
+		for ; b > 0; b >>= 1 {
+			if b&1 == 1 {
+				p ^= a
+			}
+			carry := a&0x80 != 0
+			a <<= 1
+			if carry {
+				a ^= 0x1d
+			}
+		}
+		return p
+	}
+
+	for i := 0; i < ecLen; i++ {
+		var s byte
+		for _, cw := range block {
+			s = mul(s, exp[i]) ^ cw
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_EncodeTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("a", 2331)); err != nil {
+		t.Errorf("expected the largest code to hold 2331 bytes, but got %s", err)
+	}
+
+	if _, err := Encode(strings.Repeat("a", 2332)); err != ErrTooLong {
+		t.Errorf("expected ErrTooLong but got %v", err)
+	}
+}
+
+func Test_SVG(t *testing.T) {
+	code, _ := Encode("hello")
+	svg := code.SVG(4)
+
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="116" height="116" viewBox="0 0 29 29"`) {
+		t.Errorf("unexpected svg %s", svg)
+	}
+
+	// the top left finder pattern starts inside the quiet zone
+	if !strings.Contains(svg, `d="M4 4h1v1h-1z`) {
+		t.Errorf("expected the first dark module at 4,4 in %s", svg)
+	}
+}
@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"webApp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// usersEmailKey is the unique index on users.email, see migration 0007
+const usersEmailKey = "users_email_key"
+
+// dbError translates the errors of both drivers into the errors of the
+// repository package. Errors it does not know are returned as they are.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			if pgErr.ConstraintName == usersEmailKey {
+				return repository.ErrDuplicateEmail
+			}
+			return fmt.Errorf("%w: %s", repository.ErrConflict, pgErr.Message)
+		case pgForeignKeyViolation:
+			return fmt.Errorf("%w: %s", repository.ErrNotFound, pgErr.Message)
+		}
+		return err
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			// SQLite names the columns rather than the index
+			if strings.Contains(sqliteErr.Error(), "users.email") {
+				return repository.ErrDuplicateEmail
+			}
+			return fmt.Errorf("%w: %s", repository.ErrConflict, sqliteErr.Error())
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return fmt.Errorf("%w: %s", repository.ErrNotFound, sqliteErr.Error())
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v4"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// database/sql driver names, as returned by Open
+const (
+	DriverPostgres = "pgx"
+	DriverSQLite   = "sqlite"
+)
+
+// sqliteOptions turn on foreign keys, which SQLite leaves off by default, wait
+// for locks instead of failing, and store times in a format that sorts.
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+// ParseDSN returns the driver for dsn, and the data source to open it with. A
+// dsn starting with sqlite: names a SQLite file, e.g. sqlite:./users.db or
+// sqlite::memory:; anything else is a Postgres connection string.
+func ParseDSN(dsn string) (driver, source string) {
+	if !strings.HasPrefix(dsn, "sqlite:") {
+		return DriverPostgres, dsn
+	}
+
+	source = strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite:"), "//")
+	if strings.Contains(source, "?") {
+		return DriverSQLite, source + "&" + sqliteOptions
+	}
+	return DriverSQLite, source + "?" + sqliteOptions
+}
+
+// Open connects to the database dsn names, see ParseDSN, and returns it with
+// the driver it uses.
+func Open(dsn string) (*sql.DB, string, error) {
+	driver, source := ParseDSN(dsn)
+
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// SQLite allows one writer at a time, and every connection to :memory: is
+	// a database of its own
+	if driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, "", err
+	}
+
+	return db, driver, nil
+}
+
+// New returns the DatabaseRepo for a database opened with driver. Queries
+// taking longer than timeout are cancelled; 0 means DefaultTimeout.
+func New(db *sql.DB, driver string, hasher data.PasswordHasher, timeout time.Duration) repository.DatabaseRepo {
+	if driver == DriverSQLite {
+		return &SQLiteDBRepo{PostgresDBRepo{DB: db, Hasher: hasher, Timeout: timeout}}
+	}
+	return &PostgresDBRepo{DB: db, Hasher: hasher, Timeout: timeout}
+}
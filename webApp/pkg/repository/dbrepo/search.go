@@ -0,0 +1,81 @@
+package dbrepo
+
+import (
+	"sort"
+	"strings"
+	"webApp/pkg/data"
+)
+
+// searchWords splits a search query into lower case words
+func searchWords(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// prefixQuery turns words into a Postgres tsquery that every word must begin
+// a lexeme of, leaving out the characters tsquery gives a meaning to
+func prefixQuery(words []string) string {
+	var terms []string
+	for _, word := range words {
+		word = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`&|!():*'\<>`, r) {
+				return -1
+			}
+			return r
+		}, word)
+
+		if word != "" {
+			terms = append(terms, "'"+word+"':*")
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// searchRank scores how well u matches words, for the repositories without
+// full-text search: a word that is one of the user's words counts more than
+// one that only begins it. It is 0 when any word matches nothing.
+func searchRank(u *data.User, words []string) int {
+	fields := append(strings.Fields(strings.ToLower(u.FirstName+" "+u.LastName)), strings.ToLower(u.Email))
+
+	rank := 0
+	for _, word := range words {
+		best := 0
+		for _, field := range fields {
+			switch {
+			case field == word:
+				best = 2
+			case best == 0 && strings.HasPrefix(field, word):
+				best = 1
+			}
+		}
+
+		if best == 0 {
+			return 0
+		}
+		rank += best
+	}
+
+	return rank
+}
+
+// rankUsers returns up to limit of users that match words, best first, and
+// otherwise in the order they were given
+func rankUsers(users []*data.User, words []string, limit int) []*data.User {
+	var matches []*data.User
+	ranks := map[*data.User]int{}
+
+	for _, u := range users {
+		if rank := searchRank(u, words); rank > 0 {
+			matches = append(matches, u)
+			ranks[u] = rank
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return ranks[matches[i]] > ranks[matches[j]]
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -0,0 +1,883 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// MemoryDBRepo is a DatabaseRepo that keeps everything in memory. It behaves
+// like the SQL repositories: ids count up from 1, email addresses are unique,
+// passwords are hashed, deleted users are hidden, and it returns the same
+// errors from the repository package. Like a database, it fails calls whose
+// context is already done. It is safe for concurrent use.
+type MemoryDBRepo struct {
+	// Hasher is used for new and reset passwords; nil means bcrypt at data.DefaultBcryptCost
+	Hasher data.PasswordHasher
+
+	mu         sync.RWMutex
+	users      map[int]*memoryUser
+	images     map[int]data.UserImage
+	mfa        map[int]data.UserMFA
+	apiKeys    map[int]data.APIKey
+	identities map[int]data.UserIdentity
+	audit      []data.AuditEvent
+	lastID     map[string]int
+
+	// inTx is set on the copy WithTx hands out
+	inTx bool
+}
+
+type memoryUser struct {
+	data.User
+	deletedAt *time.Time
+}
+
+// Fixtures is the data to seed a MemoryDBRepo with. Everything is stored as it
+// is, like rows loaded from a dump: IDs are kept, and user passwords must
+// already be hashed. DeletedUsers count as deleted at their UpdatedAt. Users
+// without a Version start at 1, like new rows.
+type Fixtures struct {
+	Users        []data.User
+	DeletedUsers []data.User
+	Images       []data.UserImage
+	MFA          []data.UserMFA
+	APIKeys      []data.APIKey
+	Identities   []data.UserIdentity
+	AuditEvents  []data.AuditEvent
+}
+
+// NewMemoryDBRepo returns an empty in-memory repository.
+func NewMemoryDBRepo(hasher data.PasswordHasher) *MemoryDBRepo {
+	return &MemoryDBRepo{
+		Hasher: hasher,
+		users: map[int]*memoryUser{},
+		images: map[int]data.UserImage{},
+		mfa: map[int]data.UserMFA{},
+		apiKeys: map[int]data.APIKey{},
+		identities: map[int]data.UserIdentity{},
+		lastID: map[string]int{},
+	}
+}
+
+// Seed adds fixtures to the repository. New rows get ids after the highest
+// id seeded.
+func (m *MemoryDBRepo) Seed(f Fixtures) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range f.Users {
+		if u.Version == 0 {
+			u.Version = 1
+		}
+		m.users[u.ID] = &memoryUser{User: u}
+		m.seen("users", u.ID)
+	}
+	for _, u := range f.DeletedUsers {
+		if u.Version == 0 {
+			u.Version = 1
+		}
+		deletedAt := u.UpdatedAt
+		m.users[u.ID] = &memoryUser{User: u, deletedAt: &deletedAt}
+		m.seen("users", u.ID)
+	}
+	for _, i := range f.Images {
+		m.images[i.ID] = i
+		m.seen("user_images", i.ID)
+	}
+	for _, userMFA := range f.MFA {
+		m.mfa[userMFA.UserID] = userMFA
+	}
+	for _, k := range f.APIKeys {
+		m.apiKeys[k.ID] = k
+		m.seen("api_keys", k.ID)
+	}
+	for _, i := range f.Identities {
+		m.identities[i.ID] = i
+		m.seen("user_identities", i.ID)
+	}
+	for _, e := range f.AuditEvents {
+		m.audit = append(m.audit, e)
+		m.seen("audit_events", e.ID)
+	}
+}
+
+// seen makes sure the next id for table is after id
+func (m *MemoryDBRepo) seen(table string, id int) {
+	if id > m.lastID[table] {
+		m.lastID[table] = id
+	}
+}
+
+func (m *MemoryDBRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func (m *MemoryDBRepo) hasher() data.PasswordHasher {
+	if m.Hasher == nil {
+		return data.BcryptHasher{Cost: data.DefaultBcryptCost}
+	}
+	return m.Hasher
+}
+
+// activeUser returns the user with id, unless there is none or it is deleted
+func (m *MemoryDBRepo) activeUser(id int) (*memoryUser, bool) {
+	u, ok := m.users[id]
+	if !ok || u.deletedAt != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// emailTaken reports whether a user other than id has email
+func (m *MemoryDBRepo) emailTaken(email string, id int) bool {
+	for _, u := range m.users {
+		if u.ID != id && u.deletedAt == nil && u.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// withProfilePic returns a copy of the user, with its profile picture
+func (m *MemoryDBRepo) withProfilePic(u *memoryUser) *data.User {
+	user := u.User
+	user.ProfilePic = data.UserImage{}
+	for _, i := range m.images {
+		if i.UserID == u.ID {
+			user.ProfilePic = i
+		}
+	}
+	return &user
+}
+
+// WithTx runs fn on a copy of the repository, and keeps what fn changed only
+// when it returns nil. Nothing else can use the repository until fn returns,
+// so fn must only use repo. Calling WithTx on repo again joins the unit of work.
+func (m *MemoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.clone()
+	tx.inTx = true
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	// like a database, give up on a transaction whose context is done
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.users, m.images, m.mfa, m.apiKeys, m.identities, m.audit, m.lastID =
+		tx.users, tx.images, tx.mfa, tx.apiKeys, tx.identities, tx.audit, tx.lastID
+
+	return nil
+}
+
+// clone returns a copy of the repository that can be changed without changing
+// this one. The caller must hold the lock.
+func (m *MemoryDBRepo) clone() *MemoryDBRepo {
+	c := NewMemoryDBRepo(m.Hasher)
+
+	for id, u := range m.users {
+		user := *u
+		c.users[id] = &user
+	}
+	for id, i := range m.images {
+		c.images[id] = i
+	}
+	for id, userMFA := range m.mfa {
+		userMFA.RecoveryCodes = append([]string(nil), userMFA.RecoveryCodes...)
+		c.mfa[id] = userMFA
+	}
+	for id, k := range m.apiKeys {
+		c.apiKeys[id] = k
+	}
+	for id, i := range m.identities {
+		c.identities[id] = i
+	}
+	c.audit = append([]data.AuditEvent(nil), m.audit...)
+	for table, id := range m.lastID {
+		c.lastID[table] = id
+	}
+
+	return c
+}
+
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// AllUsers returns all users as a slice of *data.User
+func (m *MemoryDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []*data.User
+	for _, u := range m.users {
+		if u.deletedAt == nil {
+			user := u.User
+			users = append(users, &user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+// SearchUsers returns up to limit users matching query, ranked by searchRank;
+// see repository.DatabaseRepo.
+func (m *MemoryDBRepo) SearchUsers(ctx context.Context, query string, limit int) ([]*data.User, error) {
+	users, err := m.AllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rankUsers(users, searchWords(query), limit), nil
+}
+
+// EachUser calls fn for every user that is not deleted, ordered like AllUsers.
+// fn runs without the lock held, so it may use the repository.
+func (m *MemoryDBRepo) EachUser(ctx context.Context, fn func(u *data.User) error) error {
+	users, err := m.AllUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUser returns one user by id
+func (m *MemoryDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.activeUser(id)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	return m.withProfilePic(u), nil
+}
+
+// GetUserByEmail returns one user by email address
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.deletedAt == nil && u.Email == email {
+			return m.withProfilePic(u), nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// UpdateUser updates one user. Unless u.Version is 0, it must be the version
+// stored, or repository.ErrVersionMismatch is returned.
+func (m *MemoryDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.activeUser(u.ID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	if u.Version != 0 && u.Version != stored.Version {
+		return repository.ErrVersionMismatch
+	}
+
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrDuplicateEmail
+	}
+
+	stored.Email = u.Email
+	stored.FirstName = u.FirstName
+	stored.LastName = u.LastName
+	stored.IsAdmin = u.IsAdmin
+	stored.UpdatedAt = time.Now()
+	stored.Version++
+
+	return nil
+}
+
+// DeleteUser soft deletes one user, by id. It returns repository.ErrNotFound when there
+// is no such user, or it is already deleted.
+func (m *MemoryDBRepo) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.activeUser(id)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	now := time.Now()
+	u.deletedAt = &now
+
+	return nil
+}
+
+// RestoreUser undoes the soft delete of a user. It returns repository.ErrNotFound when
+// there is no such deleted user.
+func (m *MemoryDBRepo) RestoreUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.deletedAt == nil {
+		return repository.ErrNotFound
+	}
+
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrDuplicateEmail
+	}
+
+	u.deletedAt = nil
+	u.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore,
+// together with everything that belongs to them. It returns the ids of the
+// purged users, and the image files no remaining user refers to.
+func (m *MemoryDBRepo) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]int, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := map[int]bool{}
+	var ids []int
+	for id, u := range m.users {
+		if u.deletedAt != nil && u.deletedAt.Before(deletedBefore) {
+			purged[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	// uploads share one directory, so a file may also be another user's picture
+	kept := map[string]bool{}
+	for _, i := range m.images {
+		if !purged[i.UserID] {
+			kept[i.FileName] = true
+		}
+	}
+
+	var files []string
+	for id, i := range m.images {
+		if purged[i.UserID] {
+			if !kept[i.FileName] {
+				files = append(files, i.FileName)
+				kept[i.FileName] = true
+			}
+			delete(m.images, id)
+		}
+	}
+
+	for id := range purged {
+		delete(m.users, id)
+		delete(m.mfa, id)
+	}
+	for id, k := range m.apiKeys {
+		if purged[k.UserID] {
+			delete(m.apiKeys, id)
+		}
+	}
+	for id, i := range m.identities {
+		if purged[i.UserID] {
+			delete(m.identities, id)
+		}
+	}
+
+	return ids, files, nil
+}
+
+// InsertUser inserts a new user, and returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := m.hasher().Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	user.Password = hashedPassword
+	return m.InsertHashedUser(ctx, user)
+}
+
+// InsertHashedUser inserts a new user whose password is already hashed, and
+// returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertHashedUser(ctx context.Context, user data.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	user.ID = m.nextID("users")
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	user.ProfilePic = data.UserImage{}
+	user.Version = 1
+	m.users[user.ID] = &memoryUser{User: user}
+
+	return user.ID, nil
+}
+
+// ResetPassword is the method we will use to change a user's password.
+func (m *MemoryDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.activeUser(id)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	u.Password = hashedPassword
+
+	return nil
+}
+
+// InsertUserImage replaces the profile image of a user.
+func (m *MemoryDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[i.UserID]; !ok {
+		return 0, fmt.Errorf("%w: user %d", repository.ErrNotFound, i.UserID)
+	}
+
+	for id, image := range m.images {
+		if image.UserID == i.UserID {
+			delete(m.images, id)
+		}
+	}
+
+	i.ID = m.nextID("user_images")
+	i.CreatedAt = time.Now()
+	i.UpdatedAt = time.Now()
+	m.images[i.ID] = i
+
+	return i.ID, nil
+}
+
+// GetUserMFA returns the two-factor settings for a user.
+func (m *MemoryDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userMFA, ok := m.mfa[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	userMFA.RecoveryCodes = append([]string(nil), userMFA.RecoveryCodes...)
+	return &userMFA, nil
+}
+
+// SaveUserMFA inserts or updates the two-factor settings for a user.
+func (m *MemoryDBRepo) SaveUserMFA(ctx context.Context, userMFA data.UserMFA) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userMFA.UserID]; !ok {
+		return fmt.Errorf("%w: user %d", repository.ErrNotFound, userMFA.UserID)
+	}
+
+	// like the columns Postgres does not update
+	if existing, ok := m.mfa[userMFA.UserID]; ok {
+		userMFA.CreatedAt = existing.CreatedAt
+		userMFA.LastUsedStep = existing.LastUsedStep
+		userMFA.FailedAttempts = existing.FailedAttempts
+		userMFA.LockedUntil = existing.LockedUntil
+	} else {
+		userMFA.LastUsedStep, userMFA.FailedAttempts, userMFA.LockedUntil = 0, 0, nil
+		userMFA.CreatedAt = time.Now()
+	}
+	userMFA.UpdatedAt = time.Now()
+	userMFA.RecoveryCodes = append([]string(nil), userMFA.RecoveryCodes...)
+	m.mfa[userMFA.UserID] = userMFA
+
+	return nil
+}
+
+// DeleteUserMFA removes the two-factor settings and recovery codes for a user.
+func (m *MemoryDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfa, userID)
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code, given its hash.
+func (m *MemoryDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMFA, ok := m.mfa[userID]
+	if !ok {
+		return false, nil
+	}
+
+	for i, hash := range userMFA.RecoveryCodes {
+		if hash == codeHash {
+			userMFA.RecoveryCodes = append(userMFA.RecoveryCodes[:i:i], userMFA.RecoveryCodes[i+1:]...)
+			userMFA.FailedAttempts, userMFA.LockedUntil = 0, nil
+			m.mfa[userID] = userMFA
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UseTOTPStep records the step a TOTP code was accepted for, unless a code of
+// that step or a later one already was.
+func (m *MemoryDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMFA, ok := m.mfa[userID]
+	if !ok || userMFA.LastUsedStep >= step {
+		return false, nil
+	}
+
+	userMFA.LastUsedStep = step
+	userMFA.FailedAttempts, userMFA.LockedUntil = 0, nil
+	m.mfa[userID] = userMFA
+	return true, nil
+}
+
+// BeginMFAAttempt counts an attempt at a code, unless the user is locked out.
+func (m *MemoryDBRepo) BeginMFAAttempt(ctx context.Context, userID int, limit int, now, lockedUntil time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMFA, ok := m.mfa[userID]
+	if !ok || (userMFA.LockedUntil != nil && userMFA.LockedUntil.After(now)) {
+		return false, nil
+	}
+
+	userMFA.FailedAttempts++
+	if userMFA.FailedAttempts >= limit {
+		userMFA.LockedUntil = &lockedUntil
+	}
+	m.mfa[userID] = userMFA
+	return true, nil
+}
+
+// AllAPIKeys returns every API key of a user, revoked ones included
+func (m *MemoryDBRepo) AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []*data.APIKey
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			key := k
+			keys = append(keys, &key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns one API key by the hash of the key
+func (m *MemoryDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.Hash == hash {
+			// keys of deleted users stop working
+			if _, ok := m.activeUser(k.UserID); !ok {
+				break
+			}
+			return &k, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// InsertAPIKey inserts a new API key, and returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[k.UserID]; !ok {
+		return 0, fmt.Errorf("%w: user %d", repository.ErrNotFound, k.UserID)
+	}
+
+	for _, existing := range m.apiKeys {
+		if existing.Hash == k.Hash {
+			return 0, fmt.Errorf("%w: api key already exists", repository.ErrConflict)
+		}
+	}
+
+	k.ID = m.nextID("api_keys")
+	k.CreatedAt = time.Now()
+	k.LastUsedAt = nil
+	k.RevokedAt = nil
+	m.apiKeys[k.ID] = k
+
+	return k.ID, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys. It returns repository.ErrNotFound when
+// the user has no such key.
+func (m *MemoryDBRepo) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.UserID != userID || k.Revoked() {
+		return repository.ErrNotFound
+	}
+
+	now := time.Now()
+	k.RevokedAt = &now
+	m.apiKeys[id] = k
+
+	return nil
+}
+
+// TouchAPIKey records that an API key has just been used
+func (m *MemoryDBRepo) TouchAPIKey(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.apiKeys[id]; ok {
+		now := time.Now()
+		k.LastUsedAt = &now
+		m.apiKeys[id] = k
+	}
+
+	return nil
+}
+
+// GetUserIdentity returns the identity a provider knows by subject
+func (m *MemoryDBRepo) GetUserIdentity(ctx context.Context, provider, subject string) (*data.UserIdentity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, i := range m.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return &i, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// InsertUserIdentity links an external identity to a user, and returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertUserIdentity(ctx context.Context, i data.UserIdentity) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[i.UserID]; !ok {
+		return 0, fmt.Errorf("%w: user %d", repository.ErrNotFound, i.UserID)
+	}
+
+	for _, existing := range m.identities {
+		if existing.Provider == i.Provider && existing.Subject == i.Subject {
+			return 0, fmt.Errorf("%w: identity is already linked", repository.ErrConflict)
+		}
+	}
+
+	i.ID = m.nextID("user_identities")
+	i.CreatedAt = time.Now()
+	m.identities[i.ID] = i
+
+	return i.ID, nil
+}
+
+// InsertAuditEvent appends an event to the audit log, and returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.nextID("audit_events")
+	e.CreatedAt = time.Now()
+	m.audit = append(m.audit, e)
+
+	return e.ID, nil
+}
+
+// AllAuditEvents returns the audit events matching f, newest first
+func (m *MemoryDBRepo) AllAuditEvents(ctx context.Context, f data.AuditFilter) ([]*data.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*data.AuditEvent
+	for _, e := range m.audit {
+		if (f.ActorID != 0 && e.ActorID != f.ActorID) ||
+			(f.TargetUserID != 0 && e.TargetUserID != f.TargetUserID) ||
+			(f.Action != "" && e.Action != f.Action) ||
+			(!f.Since.IsZero() && e.CreatedAt.Before(f.Since)) ||
+			(!f.Until.IsZero() && !e.CreatedAt.Before(f.Until)) {
+			continue
+		}
+		event := e
+		events = append(events, &event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].ID > events[j].ID
+	})
+
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[:f.Limit]
+	}
+
+	return events, nil
+}
@@ -0,0 +1,72 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/repotest"
+)
+
+func TestMemoryDBRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryDBRepo(NewTestDBRepo().Hasher)
+	})
+}
+
+func TestMemoryDBRepo_InsertUser(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTestDBRepo()
+
+	id, err := repo.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != TestDeletedUserID+1 {
+		t.Errorf("expected the id after the fixtures, but got %d", id)
+	}
+
+	user, err := repo.GetUserByEmail(ctx, "jack@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := user.PasswordMatches("secret"); !ok {
+		t.Error("password was not hashed, or does not match")
+	}
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 3 {
+		t.Errorf("expected 3 users, but got %d", len(users))
+	}
+
+	_, err = repo.InsertUser(ctx, data.User{FirstName: "Other", LastName: "Admin", Email: "admin@example.com", Password: "secret"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, but got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryDBRepo(NewTestDBRepo().Hasher)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// everyone wants the same address; exactly one of us gets it
+			_, _ = repo.InsertUser(ctx, data.User{FirstName: "Same", LastName: "Person", Email: "same@example.com", Password: "secret"})
+			_, _ = repo.AllUsers(ctx)
+		}()
+	}
+	wg.Wait()
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, but got %d", len(users))
+	}
+}
@@ -3,34 +3,108 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 	"webApp/pkg/data"
-
-	"golang.org/x/crypto/bcrypt"
+	"webApp/pkg/repository"
 )
 
-const dbTimeout = time.Second * 3
+// DefaultTimeout is how long a query may take when no Timeout is set.
+const DefaultTimeout = time.Second * 3
 
+// PostgresDBRepo is a DatabaseRepo backed by Postgres. Every method runs with
+// the context it is given, so a request that goes away cancels its queries,
+// limited to Timeout.
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// Hasher is used for new and reset passwords; nil means bcrypt at data.DefaultBcryptCost
+	Hasher data.PasswordHasher
+	// Timeout limits every query; 0 means DefaultTimeout
+	Timeout time.Duration
+
+	// tx is set on the copy WithTx hands out, and every query runs in it
+	tx *sql.Tx
+}
+
+// querier is what the queries need of a *sql.DB or a *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// db returns the transaction the repository is in, or else the database
+func (m *PostgresDBRepo) db() querier {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+func (m *PostgresDBRepo) timeout() time.Duration {
+	if m.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return m.Timeout
+}
+
+func (m *PostgresDBRepo) hasher() data.PasswordHasher {
+	if m.Hasher == nil {
+		return data.BcryptHasher{Cost: data.DefaultBcryptCost}
+	}
+	return m.Hasher
 }
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
+// WithTx runs fn in a transaction, which is committed when fn returns nil and
+// rolled back otherwise. Everything fn does through repo is part of the
+// transaction; calling WithTx on repo again joins it.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn with a copy of the repository whose queries all run in one
+// transaction, or with the repository itself when it is in one already.
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	repo := *m
+	repo.tx = tx
+
+	if err := fn(&repo); err != nil {
+		return err
+	}
+
+	return dbError(tx.Commit())
+}
+
 // AllUsers returns all users as a slice of *data.User
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
+	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, version
+	from users where deleted_at is null order by last_name, id`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.db().QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
@@ -47,10 +121,11 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.IsAdmin,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Version,
 		)
 		if err != nil {
 			log.Println("Error scanning", err)
-			return nil, err
+			return nil, dbError(err)
 		}
 
 		users = append(users, &user)
@@ -59,22 +134,124 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 	return users, nil
 }
 
+// eachUserBatch is how many users EachUser reads per query; a variable for tests
+var eachUserBatch = 500
+
+// EachUser calls fn for every user that is not deleted, ordered like AllUsers.
+// Users are read in batches, each starting after the last user of the one
+// before, so that no query runs longer than the timeout and no connection is
+// held while fn sends users to a slow client.
+func (m *PostgresDBRepo) EachUser(ctx context.Context, fn func(u *data.User) error) error {
+	lastName, lastID := "", 0
+
+	for {
+		users, err := m.usersAfter(ctx, lastName, lastID, eachUserBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < eachUserBatch {
+			return nil
+		}
+		lastName, lastID = users[len(users)-1].LastName, users[len(users)-1].ID
+	}
+}
+
+// usersAfter returns up to limit users that come after lastName, lastID in
+// the order of AllUsers
+func (m *PostgresDBRepo) usersAfter(ctx context.Context, lastName string, lastID, limit int) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, version
+	from users where deleted_at is null and (last_name, id) > ($1, $2) order by last_name, id limit $3`
+
+	return m.queryUsers(ctx, query, lastName, lastID, limit)
+}
+
+// userSearchText is what SearchUsers searches. Migration 0010 indexes this
+// expression, so the two must not change without each other.
+const userSearchText = `lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''))`
+
+// SearchUsers returns up to limit users matching query, best first: every word
+// must begin a word of the user's names or email, or the query must be near
+// one of them, by trigram word similarity.
+func (m *PostgresDBRepo) SearchUsers(ctx context.Context, query string, limit int) ([]*data.User, error) {
+	words := searchWords(query)
+	tsquery := prefixQuery(words)
+	if tsquery == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	stmt := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, version
+	from users, to_tsquery('simple', $1) q
+	where deleted_at is null
+		and (to_tsvector('simple', ` + userSearchText + `) @@ q or $2 <% ` + userSearchText + `)
+	order by ts_rank(to_tsvector('simple', ` + userSearchText + `), q) + word_similarity($2, ` + userSearchText + `) desc, last_name, id
+	limit $3`
+
+	return m.queryUsers(ctx, stmt, tsquery, strings.Join(words, " "), limit)
+}
+
+// queryUsers runs query, which selects the columns AllUsers does, and returns
+// the users it finds
+func (m *PostgresDBRepo) queryUsers(ctx context.Context, query string, args ...any) ([]*data.User, error) {
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var users []*data.User
+
+	for rows.Next() {
+		var user data.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.IsAdmin,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, dbError(err)
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, dbError(rows.Err())
+}
+
 // GetUser returns one user by id
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, u.version, coalesce(ui.file_name, '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.id = $1`
+		    u.id = $1 and u.deleted_at is null`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.db().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -85,32 +262,33 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 
 	return &user, nil
 }
 
 // GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, u.version, coalesce(ui.file_name, '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.email = $1`
+		    u.email = $1 and u.deleted_at is null`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.db().QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -121,136 +299,744 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 
 	return &user, nil
 }
 
-// UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateUser updates one user in the database, and bumps its version by one.
+// Unless u.Version is 0, it must be the version stored, or
+// repository.ErrVersionMismatch is returned. It returns
+// repository.ErrNotFound when there is no such user, and
+// repository.ErrDuplicateEmail when another user has the new address.
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	stmt := `update users set
-		email = $1,
-		first_name = $2,
-		last_name = $3,
-		is_admin = $4,
-		updated_at = $5
-		where id = $6
-	`
-
-	_, err := m.DB.ExecContext(ctx, stmt,
-		u.Email,
-		u.FirstName,
-		u.LastName,
-		u.IsAdmin,
-		time.Now(),
-		u.ID,
-	)
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `update users set
+			email = $1,
+			first_name = $2,
+			last_name = $3,
+			is_admin = $4,
+			updated_at = $5,
+			version = version + 1
+			where id = $6 and deleted_at is null and ($7 = 0 or version = $7)
+		`
+
+		result, err := tx.db().ExecContext(ctx, stmt,
+			u.Email,
+			u.FirstName,
+			u.LastName,
+			u.IsAdmin,
+			time.Now(),
+			u.ID,
+			u.Version,
+		)
+
+		if err != nil {
+			return dbError(err)
+		}
+
+		err = requireRowsAffected(result)
+		if err == nil || u.Version == 0 {
+			return err
+		}
+
+		// nothing changed: either the user is gone, or someone else got there first
+		var exists int
+		err = tx.db().QueryRowContext(ctx, `select 1 from users where id = $1 and deleted_at is null`, u.ID).Scan(&exists)
+		if err != nil {
+			return dbError(err)
+		}
+
+		return repository.ErrVersionMismatch
+	})
+}
 
+// DeleteUser soft deletes one user, by id. The user is hidden from every
+// lookup until restored, and removed for good by PurgeDeletedUsers. It returns
+// repository.ErrNotFound when there is no such user, or it is already deleted.
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	stmt := `update users set deleted_at = $1 where id = $2 and deleted_at is null`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
-	return nil
+	return requireRowsAffected(result)
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// RestoreUser undoes the soft delete of a user. It returns
+// repository.ErrNotFound when there is no such deleted user, and
+// repository.ErrDuplicateEmail when another user has taken its address since.
+func (m *PostgresDBRepo) RestoreUser(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	stmt := `update users set deleted_at = null, updated_at = $1 where id = $2 and deleted_at is not null`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := m.db().ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
-	return nil
+	return requireRowsAffected(result)
 }
 
-// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore,
+// together with everything that belongs to them. It returns the ids of the
+// purged users, and the image files no remaining user refers to, which the
+// caller should remove from disk.
+func (m *PostgresDBRepo) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]int, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	var ids []int
+	var files []string
+
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		// uploads share one directory, so a file may also be another user's picture
+		query := `select distinct ui.file_name
+			from user_images ui
+				join users u on (u.id = ui.user_id)
+			where u.deleted_at < $1
+				and not exists (
+					select 1 from user_images o join users ou on (ou.id = o.user_id)
+					where o.file_name = ui.file_name and (ou.deleted_at is null or ou.deleted_at >= $1)
+				)`
+
+		rows, err := tx.db().QueryContext(ctx, query, deletedBefore)
+		if err != nil {
+			return dbError(err)
+		}
+
+		for rows.Next() {
+			var file string
+			if err := rows.Scan(&file); err != nil {
+				rows.Close()
+				return dbError(err)
+			}
+			files = append(files, file)
+		}
+		rows.Close()
+
+		// images, two-factor settings, api keys and identities go with the user
+		rows, err = tx.db().QueryContext(ctx, `delete from users where deleted_at < $1 returning id`, deletedBefore)
+		if err != nil {
+			return dbError(err)
+		}
+
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return dbError(err)
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ids, files, nil
+}
+
+// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	hashedPassword, err := m.hasher().Hash(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
+	user.Password = hashedPassword
+	return m.InsertHashedUser(ctx, user)
+}
+
+// InsertHashedUser inserts a new user whose password is already hashed, and
+// returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertHashedUser(ctx context.Context, user data.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
 	var newID int
 	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
 		values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err := m.db().QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
-		hashedPassword,
+		user.Password,
 		user.IsAdmin,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// It returns repository.ErrNotFound when there is no such user.
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := m.hasher().Hash(password)
 	if err != nil {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	stmt := `update users set password = $1 where id = $2 and deleted_at is null`
+	result, err := m.db().ExecContext(ctx, stmt, hashedPassword, id)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
-	return nil
+	return requireRowsAffected(result)
 }
 
-// InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// InsertUserImage replaces the profile image of a user. The old image is only
+// gone once the new one is stored.
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
 	defer cancel()
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	var newID int
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `delete from user_images where user_id = $1`
+		_, err := tx.db().ExecContext(ctx, stmt, i.UserID)
+		if err != nil {
+			return dbError(err)
+		}
+
+		stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+		err = tx.db().QueryRowContext(ctx, stmt,
+			i.UserID,
+			i.FileName,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+
+		return dbError(err)
+	})
 	if err != nil {
 		return 0, err
 	}
 
+	return newID, nil
+}
+
+// GetUserMFA returns the two-factor settings for a user, including the hashes
+// of any recovery codes that have not been used yet.
+func (m *PostgresDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	query := `select user_id, secret, enabled, last_used_step, failed_attempts, locked_until, created_at, updated_at
+	from user_mfa where user_id = $1`
+
+	var mfa data.UserMFA
+	var lockedUntil sql.NullTime
+	err := m.db().QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.Enabled,
+		&mfa.LastUsedStep,
+		&mfa.FailedAttempts,
+		&lockedUntil,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if lockedUntil.Valid {
+		mfa.LockedUntil = &lockedUntil.Time
+	}
+
+	rows, err := m.db().QueryContext(ctx, `select code_hash from user_mfa_recovery_codes where user_id = $1 order by id`, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, dbError(err)
+		}
+		mfa.RecoveryCodes = append(mfa.RecoveryCodes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	return &mfa, nil
+}
+
+// SaveUserMFA inserts or updates the two-factor settings for a user. The
+// stored recovery codes are replaced by userMFA.RecoveryCodes, which must already
+// be hashed.
+func (m *PostgresDBRepo) SaveUserMFA(ctx context.Context, userMFA data.UserMFA) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	stmt := `insert into user_mfa (user_id, secret, enabled, created_at, updated_at)
+		values ($1, $2, $3, $4, $5)
+		on conflict (user_id) do update set secret = excluded.secret, enabled = excluded.enabled, updated_at = excluded.updated_at`
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		_, err := tx.db().ExecContext(ctx, stmt,
+			userMFA.UserID,
+			userMFA.Secret,
+			userMFA.Enabled,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return dbError(err)
+		}
+
+		_, err = tx.db().ExecContext(ctx, `delete from user_mfa_recovery_codes where user_id = $1`, userMFA.UserID)
+		if err != nil {
+			return dbError(err)
+		}
+
+		for _, hash := range userMFA.RecoveryCodes {
+			_, err = tx.db().ExecContext(ctx,
+				`insert into user_mfa_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`,
+				userMFA.UserID, hash, time.Now())
+			if err != nil {
+				return dbError(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteUserMFA removes the two-factor settings and recovery codes for a user.
+func (m *PostgresDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		_, err := tx.db().ExecContext(ctx, `delete from user_mfa_recovery_codes where user_id = $1`, userID)
+		if err != nil {
+			return dbError(err)
+		}
+
+		_, err = tx.db().ExecContext(ctx, `delete from user_mfa where user_id = $1`, userID)
+		return dbError(err)
+	})
+}
+
+// UseRecoveryCode consumes a recovery code, given its hash. It returns false if
+// the code does not exist or has already been used.
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	var used bool
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `delete from user_mfa_recovery_codes where user_id = $1 and code_hash = $2`
+		result, err := tx.db().ExecContext(ctx, stmt, userID, codeHash)
+		if err != nil {
+			return dbError(err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil || n == 0 {
+			return dbError(err)
+		}
+
+		used = true
+		_, err = tx.db().ExecContext(ctx, `update user_mfa set failed_attempts = 0, locked_until = null where user_id = $1`, userID)
+		return dbError(err)
+	})
+
+	return used, err
+}
+
+// UseTOTPStep records step as the last one a TOTP code was accepted for, and
+// clears the failed attempts. It returns false if step is not later than the
+// last one.
+func (m *PostgresDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	// the condition makes it safe against two requests with the same code
+	stmt := `update user_mfa set last_used_step = $2, failed_attempts = 0, locked_until = null
+	where user_id = $1 and last_used_step < $2`
+	result, err := m.db().ExecContext(ctx, stmt, userID, step)
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	return n > 0, nil
+}
+
+// BeginMFAAttempt counts an attempt at a code, and returns false, without
+// counting it, while the user is locked out.
+func (m *PostgresDBRepo) BeginMFAAttempt(ctx context.Context, userID int, limit int, now, lockedUntil time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	// one statement, so that concurrent attempts cannot all pass the check
+	stmt := `update user_mfa set
+		failed_attempts = failed_attempts + 1,
+		locked_until = case when failed_attempts + 1 >= $2 then $4 else locked_until end
+	where user_id = $1 and (locked_until is null or locked_until <= $3)`
+	result, err := m.db().ExecContext(ctx, stmt, userID, limit, now, lockedUntil)
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	return n > 0, nil
+}
+
+// AllAPIKeys returns every API key of a user, revoked ones included
+func (m *PostgresDBRepo) AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	query := `select id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at
+	from api_keys where user_id = $1 order by id`
+
+	rows, err := m.db().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var keys []*data.APIKey
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, dbError(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns one API key by the hash of the key
+func (m *PostgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	// keys of deleted users stop working
+	query := `select k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.created_at, k.last_used_at, k.revoked_at
+	from api_keys k join users u on (u.id = k.user_id)
+	where k.key_hash = $1 and u.deleted_at is null`
+
+	key, err := scanAPIKey(m.db().QueryRowContext(ctx, query, hash))
+	return key, dbError(err)
+}
+
+// InsertAPIKey inserts a new API key, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		strings.Join(k.Scopes, ","),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys. It returns
+// repository.ErrNotFound when the user has no such key.
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and user_id = $3 and revoked_at is null`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return requireRowsAffected(result)
+}
+
+// TouchAPIKey records that an API key has just been used
+func (m *PostgresDBRepo) TouchAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	_, err := m.db().ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, time.Now(), id)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// GetUserIdentity returns the identity a provider knows by subject
+func (m *PostgresDBRepo) GetUserIdentity(ctx context.Context, provider, subject string) (*data.UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	query := `select id, user_id, provider, subject, email, created_at
+	from user_identities where provider = $1 and subject = $2`
+
+	var identity data.UserIdentity
+	err := m.db().QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return &identity, nil
+}
+
+// InsertUserIdentity links an external identity to a user, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUserIdentity(ctx context.Context, i data.UserIdentity) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	stmt := `insert into user_identities (user_id, provider, subject, email, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err := m.db().QueryRowContext(ctx, stmt,
 		i.UserID,
-		i.FileName,
+		i.Provider,
+		i.Subject,
+		i.Email,
 		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// InsertAuditEvent appends an event to the audit log, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	var details []byte
+	if len(e.Details) > 0 {
+		var err error
+		details, err = json.Marshal(e.Details)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var newID int
+	stmt := `insert into audit_events (actor_id, action, target_user_id, ip, details, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt,
+		nullInt(e.ActorID),
+		e.Action,
+		nullInt(e.TargetUserID),
+		e.IP,
+		details,
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	return newID, nil
-}
\ No newline at end of file
+}
+
+// AllAuditEvents returns the audit events matching f, newest first
+func (m *PostgresDBRepo) AllAuditEvents(ctx context.Context, f data.AuditFilter) ([]*data.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.TargetUserID != 0 {
+		add("target_user_id = $%d", f.TargetUserID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+
+	query := `select id, coalesce(actor_id, 0), action, coalesce(target_user_id, 0), coalesce(ip, ''), details, created_at
+	from audit_events`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by created_at desc, id desc"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var events []*data.AuditEvent
+
+	for rows.Next() {
+		var e data.AuditEvent
+		var details []byte
+		err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.Action,
+			&e.TargetUserID,
+			&e.IP,
+			&details,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, dbError(err)
+		}
+
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &e.Details); err != nil {
+				return nil, err
+			}
+		}
+
+		events = append(events, &e)
+	}
+
+	return events, nil
+}
+
+// nullInt stores 0 as NULL, for optional references to users
+func nullInt(i int) any {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// requireRowsAffected returns repository.ErrNotFound when a statement changed nothing
+func requireRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (*data.APIKey, error) {
+	var key data.APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&scopes,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
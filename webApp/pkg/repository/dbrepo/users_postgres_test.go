@@ -3,20 +3,22 @@ package dbrepo
 // 	buildを最初に宣言することによって,intergrationでタグ付けしてunit testと分けることができる
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"testing"
-	"time"
 	"webApp/pkg/data"
 	"webApp/pkg/repository"
+	"webApp/pkg/repository/migrations"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -31,8 +33,6 @@ var (
 
 var resource *dockertest.Resource
 var pool *dockertest.Pool
-var testDB *sql.DB
-var testRepo repository.DatabaseRepo
 
 func TestMain(m *testing.M) {
 	// connect to docker; fail if docker not running
@@ -82,7 +82,8 @@ func TestMain(m *testing.M) {
 		log.Fatalf("could not connect to database: %s", err)
 	}
 
-	// populate the database with empty tables
+	// populate the database with empty tables, by running every migration
+	testDriver = DriverPostgres
 	err = createTables()
 	if err != nil {
 		log.Fatalf("error creating tables: %s", err)
@@ -101,175 +102,75 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func createTables() error {
-	// store it as slice of byte
-	tableSQL, err := os.ReadFile("./testdata/users.sql")
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	_, err = testDB.Exec(string(tableSQL))
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	return nil
-}
+// emptyDatabases counts the databases newEmptyRepo has created
+var emptyDatabases int
 
-func Test_pingDB(t *testing.T) {
-	err := testDB.Ping()
-	if err != nil {
-		t.Error("can't ping database")
-	}
-}
+// newEmptyRepo returns a repository on a new database in the container, with
+// every migration applied
+func newEmptyRepo(t *testing.T) repository.DatabaseRepo {
+	emptyDatabases++
+	name := fmt.Sprintf("conformance_%d", emptyDatabases)
 
-func TestPostgresDBRepoInsertUser(t *testing.T) {
-	testUser := data.User{
-		FirstName: "Admin",
-		LastName: "User",
-		Email: "admin@example.com",
-		Password: "secret",
-		IsAdmin: 1,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if _, err := testDB.Exec("create database " + name); err != nil {
+		t.Fatal(err)
 	}
 
-	id, err := testRepo.InsertUser(testUser)
+	db, err := sql.Open("pgx", fmt.Sprintf(dsn, host, port, user, password, name))
 	if err != nil {
-		t.Errorf("insert user returned an error %s", err)
-	}
-
-	if id !=1 {
-		t.Errorf("insert user returned wrong id; expected 1, butgot %d", id)
+		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		db.Close()
+		_, _ = testDB.Exec("drop database " + name)
+	})
 
-}
-
-func TestPostgresDBRepoAllUsers(t *testing.T) {
-	users, err := testRepo.AllUsers()
+	migrator, err := migrations.New(db, DriverPostgres)
 	if err != nil {
-		t.Errorf("all users reports an error: %s", err)
-	}
-
-	if len(users) != 1 {
-		t.Errorf("all users reports wrong size; expected 1, but got %d", len(users))
-	}
-
-	testUser := data.User{
-		FirstName: "Jack",
-		LastName: "Smith",
-		Email: "jack@smith.com",
-		Password: "secret",
-		IsAdmin: 1,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		t.Fatal(err)
 	}
 
-	_, _ = testRepo.InsertUser(testUser)
-
-	users, err = testRepo.AllUsers()
-	if err != nil {
-		t.Errorf("all users reports an error: %s", err)
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
 	}
 
-	if len(users) != 2 {
-		t.Errorf("all users reports wrong size after insert; expected 2, but got %d", len(users))
-	}
+	return New(db, DriverPostgres, data.BcryptHasher{Cost: bcrypt.MinCost}, 0)
 }
 
-func TestPostgresDBRepoGetUser(t *testing.T) {
-	user, err := testRepo.GetUser(1)
-	if err != nil {
-		t.Errorf("error getting user by id: %s", err)
-	}
-
-	if user.Email != "admin@example.com" {
-		t.Errorf("wrong email returned by GetUser: expected admin@example.com but got %s", user.Email)
+func Test_MigrateLegacySchema(t *testing.T) {
+	if _, err := testDB.Exec("create database legacy"); err != nil {
+		t.Fatal(err)
 	}
 
-	_, err = testRepo.GetUser(3)
-	if err == nil {
-		t.Errorf("no error reported when gettig non existent user by id")
-	}
-}
-
-func TestPostgresDBRepoGetUserByEmail(t *testing.T) {
-	user, err := testRepo.GetUserByEmail("jack@smith.com")
+	db, err := sql.Open("pgx", fmt.Sprintf(dsn, host, port, user, password, "legacy"))
 	if err != nil {
-		t.Errorf("error getting user by id: %s", err)
+		t.Fatal(err)
 	}
+	defer func() {
+		db.Close()
+		_, _ = testDB.Exec("drop database legacy")
+	}()
 
-	if user.ID != 2 {
-		t.Errorf("wrong email returned by GetUserByEmail: expected 2 but got %d", user.ID)
-	}
-}
-
-func TestPostgresDBRepoUpdateUser(t *testing.T) {
-	user, _ := testRepo.GetUser(2)
-	user.FirstName = "Jane"
-	user.Email = "jane@smith.com"
-
-	err := testRepo.UpdateUser(*user)
+	// the schema docker-compose loaded before there were migrations
+	dump, err := os.ReadFile("./testdata/users_legacy.sql")
 	if err != nil {
-		t.Errorf("error updating user %d: %s", 2, err)
-	}
-
-	user, _= testRepo.GetUser(2)
-	if user.FirstName != "Jane" || user.Email != "jane@smith.com" {
-		t.Errorf("expected updated record to have first name Jane and email jane@smith.com, but get %s %s", user.FirstName, user.Email)
+		t.Fatal(err)
 	}
-}
-
-func TestPostgresDBRepoDeleteUser(t *testing.T) {
-	err := testRepo.DeleteUser(2)
-	if err != nil{
-		t.Errorf("error deleting user id 2: %s", err)
+	if _, err := db.Exec(string(dump)); err != nil {
+		t.Fatal(err)
 	}
 
-	_, err = testRepo.GetUser(2)
-	if err == nil {
-		t.Error("retrieved user id 2, who should have been deleted")
-	}
-}
-
-func TestPostgresDBRepoResetPassword(t *testing.T) {
-	err := testRepo.ResetPassword(1, "password")
+	migrator, err := migrations.New(db, DriverPostgres)
 	if err != nil {
-		t.Error("error resetting user's a password", err)
+		t.Fatal(err)
 	}
 
-	user, _ := testRepo.GetUser(1)
-	matches, err := user.PasswordMatches("password")
-	if err != nil {
-		t.Error(err)
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrating a database made from the dump: %s", err)
 	}
 
-	if !matches {
-		t.Errorf("password should match 'password', but does not")
+	// argon2id hashes are longer than the dump's password column
+	repo := New(db, DriverPostgres, data.DefaultArgon2idHasher, 0)
+	if _, err := repo.InsertUser(context.Background(), data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"}); err != nil {
+		t.Errorf("insert after migrating: %s", err)
 	}
 }
-
-func TestPostgresDBRepoInsertUserImage(t *testing.T) {
-	var image data.UserImage
-	image.UserID = 1
-	image.FileName = "test.jpg"
-	image.CreatedAt = time.Now()
-	image.UpdatedAt = time.Now()
-
-	newID, err := testRepo.InsertUserImage(image)
-	if err != nil {
-		t.Error("inserting user image failed:", err)
-	}
-
-	if newID != 1 {
-		t.Error("get wrong id for image; should be 1, but got", newID)
-	}
-
-	image.UserID = 100
-	_, err = testRepo.InsertUserImage(image)
-	if err == nil {
-		t.Error("inserted a user image with non-existent user id")
-	}
-}
\ No newline at end of file
@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// SQLiteDBRepo is a DatabaseRepo backed by SQLite, so the servers and the
+// repository tests can run without Postgres. The queries of PostgresDBRepo are
+// plain SQL that SQLite understands as well, so they are reused as they are.
+type SQLiteDBRepo struct {
+	PostgresDBRepo
+}
+
+// WithTx runs fn in a transaction, see PostgresDBRepo.WithTx. Open gives SQLite
+// a single connection, so fn must only use repo: anything else waits for the
+// transaction to end.
+func (m *SQLiteDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(&SQLiteDBRepo{*tx})
+	})
+}
+
+// SearchUsers returns up to limit users matching query, see
+// repository.DatabaseRepo. SQLite has neither full-text search nor trigrams
+// built in, so like finds the users that contain every word, and they are
+// ranked the way MemoryDBRepo ranks them.
+func (m *SQLiteDBRepo) SearchUsers(ctx context.Context, query string, limit int) ([]*data.User, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout())
+	defer cancel()
+
+	stmt := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, version
+	from users where deleted_at is null`
+
+	var args []any
+	for _, word := range words {
+		args = append(args, "%"+likeEscaper.Replace(word)+"%")
+		stmt += fmt.Sprintf(` and lower(first_name || ' ' || last_name || ' ' || email) like $%d escape '\'`, len(args))
+	}
+	stmt += ` order by last_name, id`
+
+	users, err := m.queryUsers(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rankUsers(users, words, limit), nil
+}
+
+// likeEscaper escapes the wildcards of like, for a pattern with escape '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,85 @@
+//go:build !intergration
+package dbrepo
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/migrations"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestMain runs the repository tests against a SQLite file in a temporary
+// directory; no Docker needed.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dbrepo")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	testDB, testDriver, err = Open("sqlite:" + filepath.Join(dir, "users_test.db"))
+	if err != nil {
+		log.Fatalf("could not open database: %s", err)
+	}
+
+	err = createTables()
+	if err != nil {
+		log.Fatalf("error creating tables: %s", err)
+	}
+
+	testRepo = &SQLiteDBRepo{PostgresDBRepo{DB: testDB}}
+
+	code := m.Run()
+
+	testDB.Close()
+	os.RemoveAll(dir)
+
+	os.Exit(code)
+}
+
+// newEmptyRepo returns a repository on a new SQLite file, with every migration applied
+func newEmptyRepo(t *testing.T) repository.DatabaseRepo {
+	db, driver, err := Open("sqlite:" + filepath.Join(t.TempDir(), "conformance.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db, driver, data.BcryptHasher{Cost: bcrypt.MinCost}, 0)
+}
+
+func Test_ParseDSN(t *testing.T) {
+	var tests = []struct {
+		name           string
+		dsn            string
+		expectedDriver string
+		expectedSource string
+	}{
+		{"postgres", "host=localhost port=5432", DriverPostgres, "host=localhost port=5432"},
+		{"postgres url", "postgres://localhost/users", DriverPostgres, "postgres://localhost/users"},
+		{"sqlite file", "sqlite:./users.db", DriverSQLite, "./users.db?" + sqliteOptions},
+		{"sqlite url", "sqlite://users.db?mode=rwc", DriverSQLite, "users.db?mode=rwc&" + sqliteOptions},
+		{"sqlite memory", "sqlite::memory:", DriverSQLite, ":memory:?" + sqliteOptions},
+	}
+
+	for _, e := range tests {
+		driver, source := ParseDSN(e.dsn)
+		if driver != e.expectedDriver || source != e.expectedSource {
+			t.Errorf("%s: expected %s %s but got %s %s", e.name, e.expectedDriver, e.expectedSource, driver, source)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package dbrepo
+
+// The tests in this file run against the repository TestMain sets up: SQLite
+// by default, see users_sqlite_test.go, and Postgres in docker with
+// -tags intergration, see users_postgres_test.go. They run in order, and each
+// builds on the state the previous ones left behind.
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+	"webApp/pkg/repository/migrations"
+	"webApp/pkg/repository/repotest"
+)
+
+var testDB *sql.DB
+var testDriver string
+var testRepo repository.DatabaseRepo
+
+// createTables populates the database with empty tables, by running every migration
+func createTables() error {
+	migrator, err := migrations.New(testDB, testDriver)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up(context.Background())
+	return err
+}
+
+func Test_pingDB(t *testing.T) {
+	err := testDB.Ping()
+	if err != nil {
+		t.Error("can't ping database")
+	}
+}
+
+func TestDBRepo_InsertUser(t *testing.T) {
+	testUser := data.User{
+		FirstName: "Admin",
+		LastName: "User",
+		Email: "admin@example.com",
+		Password: "secret",
+		IsAdmin: 1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	id, err := testRepo.InsertUser(context.Background(), testUser)
+	if err != nil {
+		t.Errorf("insert user returned an error %s", err)
+	}
+
+	if id !=1 {
+		t.Errorf("insert user returned wrong id; expected 1, butgot %d", id)
+	}
+
+}
+
+func TestDBRepo_AllUsers(t *testing.T) {
+	users, err := testRepo.AllUsers(context.Background())
+	if err != nil {
+		t.Errorf("all users reports an error: %s", err)
+	}
+
+	if len(users) != 1 {
+		t.Errorf("all users reports wrong size; expected 1, but got %d", len(users))
+	}
+
+	testUser := data.User{
+		FirstName: "Jack",
+		LastName: "Smith",
+		Email: "jack@smith.com",
+		Password: "secret",
+		IsAdmin: 1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	_, _ = testRepo.InsertUser(context.Background(), testUser)
+
+	users, err = testRepo.AllUsers(context.Background())
+	if err != nil {
+		t.Errorf("all users reports an error: %s", err)
+	}
+
+	if len(users) != 2 {
+		t.Errorf("all users reports wrong size after insert; expected 2, but got %d", len(users))
+	}
+}
+
+func TestDBRepo_GetUser(t *testing.T) {
+	user, err := testRepo.GetUser(context.Background(), 1)
+	if err != nil {
+		t.Errorf("error getting user by id: %s", err)
+	}
+
+	if user.Email != "admin@example.com" {
+		t.Errorf("wrong email returned by GetUser: expected admin@example.com but got %s", user.Email)
+	}
+
+	_, err = testRepo.GetUser(context.Background(), 3)
+	if err == nil {
+		t.Errorf("no error reported when gettig non existent user by id")
+	}
+}
+
+func TestDBRepo_GetUserByEmail(t *testing.T) {
+	user, err := testRepo.GetUserByEmail(context.Background(), "jack@smith.com")
+	if err != nil {
+		t.Errorf("error getting user by id: %s", err)
+	}
+
+	if user.ID != 2 {
+		t.Errorf("wrong email returned by GetUserByEmail: expected 2 but got %d", user.ID)
+	}
+}
+
+func TestDBRepo_UpdateUser(t *testing.T) {
+	user, _ := testRepo.GetUser(context.Background(), 2)
+	user.FirstName = "Jane"
+	user.Email = "jane@smith.com"
+
+	err := testRepo.UpdateUser(context.Background(), *user)
+	if err != nil {
+		t.Errorf("error updating user %d: %s", 2, err)
+	}
+
+	user, _= testRepo.GetUser(context.Background(), 2)
+	if user.FirstName != "Jane" || user.Email != "jane@smith.com" {
+		t.Errorf("expected updated record to have first name Jane and email jane@smith.com, but get %s %s", user.FirstName, user.Email)
+	}
+}
+
+func TestDBRepo_DeleteUser(t *testing.T) {
+	err := testRepo.DeleteUser(context.Background(), 2)
+	if err != nil{
+		t.Errorf("error deleting user id 2: %s", err)
+	}
+
+	_, err = testRepo.GetUser(context.Background(), 2)
+	if err == nil {
+		t.Error("retrieved user id 2, who should have been deleted")
+	}
+}
+
+func TestDBRepo_ResetPassword(t *testing.T) {
+	err := testRepo.ResetPassword(context.Background(), 1, "password")
+	if err != nil {
+		t.Error("error resetting user's a password", err)
+	}
+
+	user, _ := testRepo.GetUser(context.Background(), 1)
+	matches, err := user.PasswordMatches("password")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !matches {
+		t.Errorf("password should match 'password', but does not")
+	}
+}
+
+func TestDBRepo_InsertUserImage(t *testing.T) {
+	var image data.UserImage
+	image.UserID = 1
+	image.FileName = "test.jpg"
+	image.CreatedAt = time.Now()
+	image.UpdatedAt = time.Now()
+
+	newID, err := testRepo.InsertUserImage(context.Background(), image)
+	if err != nil {
+		t.Error("inserting user image failed:", err)
+	}
+
+	if newID != 1 {
+		t.Error("get wrong id for image; should be 1, but got", newID)
+	}
+
+	image.UserID = 100
+	_, err = testRepo.InsertUserImage(context.Background(), image)
+	if err == nil {
+		t.Error("inserted a user image with non-existent user id")
+	}
+}
+
+func TestDBRepo_AuditEvents(t *testing.T) {
+	events := []data.AuditEvent{
+		{Action: data.AuditLoginFailed, IP: "127.0.0.1", Details: map[string]any{"email": "nobody@example.com"}},
+		{ActorID: 1, Action: data.AuditLogin, TargetUserID: 1, IP: "127.0.0.1"},
+		{ActorID: 1, Action: data.AuditUserDeleted, TargetUserID: 2, IP: "127.0.0.1"},
+	}
+
+	for _, e := range events {
+		_, err := testRepo.InsertAuditEvent(context.Background(), e)
+		if err != nil {
+			t.Fatal("inserting audit event failed:", err)
+		}
+	}
+
+	all, err := testRepo.AllAuditEvents(context.Background(), data.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 3 {
+		t.Errorf("expected 3 audit events, but got %d", len(all))
+	}
+
+	// newest first
+	if all[0].Action != data.AuditUserDeleted {
+		t.Errorf("expected newest event first, but got %s", all[0].Action)
+	}
+
+	if all[2].ActorID != 0 || all[2].Details["email"] != "nobody@example.com" {
+		t.Errorf("failed login stored wrongly: %+v", all[2])
+	}
+
+	filtered, _ := testRepo.AllAuditEvents(context.Background(), data.AuditFilter{ActorID: 1, Action: data.AuditUserDeleted})
+	if len(filtered) != 1 || filtered[0].TargetUserID != 2 {
+		t.Errorf("expected the delete of user 2, but got %+v", filtered)
+	}
+
+	limited, _ := testRepo.AllAuditEvents(context.Background(), data.AuditFilter{Limit: 2})
+	if len(limited) != 2 {
+		t.Errorf("expected 2 events with limit, but got %d", len(limited))
+	}
+
+	future, _ := testRepo.AllAuditEvents(context.Background(), data.AuditFilter{Since: time.Now().Add(time.Hour)})
+	if len(future) != 0 {
+		t.Errorf("expected no events in the future, but got %d", len(future))
+	}
+}
+
+func TestDBRepo_RestoreAndPurgeUser(t *testing.T) {
+	// user 2 was deleted by TestPostgresDBRepoDeleteUser
+	err := testRepo.RestoreUser(context.Background(), 2)
+	if err != nil {
+		t.Errorf("error restoring user id 2: %s", err)
+	}
+
+	_, err = testRepo.GetUser(context.Background(), 2)
+	if err != nil {
+		t.Error("could not get user id 2 after restoring:", err)
+	}
+
+	err = testRepo.RestoreUser(context.Background(), 2)
+	if err == nil {
+		t.Error("restored a user who is not deleted")
+	}
+
+	_ = testRepo.DeleteUser(context.Background(), 2)
+
+	// not deleted long enough yet
+	ids, _, err := testRepo.PurgeDeletedUsers(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Error("purging deleted users failed:", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("purged users deleted less than an hour ago: %v", ids)
+	}
+
+	ids, _, err = testRepo.PurgeDeletedUsers(context.Background(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Error("purging deleted users failed:", err)
+	}
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("expected to purge user id 2, but purged %v", ids)
+	}
+
+	err = testRepo.RestoreUser(context.Background(), 2)
+	if err == nil {
+		t.Error("restored a purged user")
+	}
+}
+
+func TestDBRepo_MigrationsDownAndUp(t *testing.T) {
+	migrator, err := migrations.New(testDB, testDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// servers starting at the same time must not apply a migration twice
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := migrator.Up(context.Background())
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error("concurrent migrate up failed:", err)
+		}
+	}
+
+	last := migrator.Migrations[len(migrator.Migrations)-1]
+
+	rolledBack, err := migrator.Down(context.Background(), 1)
+	if err != nil {
+		t.Fatal("migrate down failed:", err)
+	}
+	if len(rolledBack) != 1 || rolledBack[0].Version != last.Version {
+		t.Errorf("expected to roll back migration %d, but rolled back %v", last.Version, rolledBack)
+	}
+
+	statuses, _ := migrator.Status(context.Background())
+	if statuses[len(statuses)-1].AppliedAt != nil {
+		t.Error("rolled back migration still shows as applied")
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatal("migrate up failed:", err)
+	}
+	if len(applied) != 1 || applied[0].Version != last.Version {
+		t.Errorf("expected to apply migration %d again, but applied %v", last.Version, applied)
+	}
+}
+
+func TestDBRepo_EachUserBatches(t *testing.T) {
+	oldBatch := eachUserBatch
+	eachUserBatch = 2
+	defer func() { eachUserBatch = oldBatch }()
+
+	repo := newEmptyRepo(t)
+
+	// two users share a last name across the end of the first batch
+	for _, u := range []data.User{
+		{FirstName: "Ann", LastName: "Adams", Email: "ann@example.com"},
+		{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com"},
+		{FirstName: "Jill", LastName: "Smith", Email: "jill@example.com"},
+		{FirstName: "Bob", LastName: "Brown", Email: "bob@example.com"},
+		{FirstName: "Zoe", LastName: "Young", Email: "zoe@example.com"},
+	} {
+		u.Password = "secret"
+		if _, err := repo.InsertUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var emails []string
+	err := repo.EachUser(context.Background(), func(u *data.User) error {
+		emails = append(emails, u.Email)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ann@example.com", "bob@example.com", "jack@example.com", "jill@example.com", "zoe@example.com"}
+	if strings.Join(emails, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, but got %v", expected, emails)
+	}
+}
+
+// TestDBRepo_Conformance runs the suite every repository passes, each part on
+// a new, empty database from newEmptyRepo
+func TestDBRepo_Conformance(t *testing.T) {
+	repotest.Run(t, newEmptyRepo)
+}
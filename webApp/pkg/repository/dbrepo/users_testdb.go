@@ -1,83 +1,82 @@
 package dbrepo
 
 import (
-	"database/sql"
-	"errors"
 	"time"
 	"webApp/pkg/data"
+	"webApp/pkg/mfa"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-type TestDBRepo struct{}
+// TestPasswordHash is the password "secret", hashed with bcrypt at cost 14.
+// Every test user has it.
+const TestPasswordHash = "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK"
 
-func (m *TestDBRepo) Connection() *sql.DB {
-	return nil
-}
+// TestMFASecret and TestRecoveryCode belong to mfa@example.com (id 2), the
+// test user that has two-factor authentication enabled.
+const (
+	TestMFASecret    = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	TestRecoveryCode = "aaaaa-bbbbb"
+)
 
-// AllUsers returns all users as a slice of *data.User
-func (m *TestDBRepo) AllUsers() ([]*data.User, error) {
-	var users []*data.User
+// API keys of the admin user (id 1): a read only key, a read/write key and a
+// revoked key.
+const (
+	TestAPIKeyRead    = "wak_test-read-only-key"
+	TestAPIKeyWrite   = "wak_test-read-write-key"
+	TestAPIKeyRevoked = "wak_test-revoked-key"
+)
 
-	return users, nil
-}
+// TestOIDCSubject is an external identity already linked to the admin user.
+const TestOIDCSubject = "linked-subject"
 
-// GetUser returns one user by id
-func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
-	var user = data.User{}
-	if id == 1 {
-		user = data.User{
-			ID: 1,
-			FirstName: "Admin",
-			LastName: "User",
-			Email: "admin@example.com",
-		}
-		return &user, nil
-	}
+// TestDeletedUserID is a soft deleted user, with profile picture TestDeletedUserImage.
+const (
+	TestDeletedUserID    = 3
+	TestDeletedUserImage = "deleted-user.png"
+)
 
-	return nil, errors.New("user not found")
+// NewTestDBRepo returns an in-memory repository seeded with TestFixtures. It
+// hashes new passwords at the lowest bcrypt cost, to keep tests fast.
+func NewTestDBRepo() *MemoryDBRepo {
+	repo := NewMemoryDBRepo(data.BcryptHasher{Cost: bcrypt.MinCost})
+	repo.Seed(TestFixtures())
+	return repo
 }
 
-// GetUserByEmail returns one user by email address
-func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	if email == "admin@example.com" {
-		user := data.User{
-			ID: 1,
-			FirstName: "Admin",
-			LastName: "User",
-			Email: "admin@example.com",
-			Password: "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
-			IsAdmin: 1,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		return &user, nil
-	}
-	return nil, errors.New("not found")
-}
+// TestFixtures returns the data handler tests expect: admin@example.com (id 1),
+// mfa@example.com (id 2) and a user deleted 60 days ago (id 3), with the keys, identities
+// and audit events described by the constants above.
+func TestFixtures() Fixtures {
+	now := time.Now()
+	deletedAt := now.AddDate(0, 0, -60)
+	revokedAt := now.Add(-time.Hour)
 
-// UpdateUser updates one user in the database
-func (m *TestDBRepo) UpdateUser(u data.User) error {
-	if u.ID == 1 {
-		return nil
+	return Fixtures{
+		Users: []data.User{
+			{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", Password: TestPasswordHash, IsAdmin: 1, CreatedAt: now, UpdatedAt: now},
+			{ID: 2, FirstName: "MFA", LastName: "User", Email: "mfa@example.com", Password: TestPasswordHash, CreatedAt: now, UpdatedAt: now},
+		},
+		DeletedUsers: []data.User{
+			{ID: TestDeletedUserID, FirstName: "Deleted", LastName: "User", Email: "deleted@example.com", Password: TestPasswordHash, CreatedAt: deletedAt, UpdatedAt: deletedAt},
+		},
+		Images: []data.UserImage{
+			{ID: 1, UserID: TestDeletedUserID, FileName: TestDeletedUserImage, CreatedAt: now, UpdatedAt: now},
+		},
+		MFA: []data.UserMFA{
+			{UserID: 2, Secret: TestMFASecret, Enabled: true, RecoveryCodes: []string{mfa.HashRecoveryCode(TestRecoveryCode)}, CreatedAt: now, UpdatedAt: now},
+		},
+		APIKeys: []data.APIKey{
+			{ID: 1, UserID: 1, Name: "read", Hash: data.HashAPIKey(TestAPIKeyRead), Scopes: []string{data.ScopeUsersRead}, CreatedAt: now},
+			{ID: 2, UserID: 1, Name: "write", Hash: data.HashAPIKey(TestAPIKeyWrite), Scopes: []string{data.ScopeUsersRead, data.ScopeUsersWrite}, CreatedAt: now},
+			{ID: 3, UserID: 1, Name: "revoked", Hash: data.HashAPIKey(TestAPIKeyRevoked), Scopes: []string{data.ScopeUsersRead}, CreatedAt: now, RevokedAt: &revokedAt},
+		},
+		Identities: []data.UserIdentity{
+			{ID: 1, UserID: 1, Provider: "https://accounts.example.com", Subject: TestOIDCSubject, Email: "admin@example.com", CreatedAt: now},
+		},
+		AuditEvents: []data.AuditEvent{
+			{ID: 1, ActorID: 1, Action: data.AuditLogin, TargetUserID: 1, IP: "127.0.0.1", CreatedAt: now.Add(-time.Hour)},
+			{ID: 2, ActorID: 1, Action: data.AuditUserDeleted, TargetUserID: 7, IP: "127.0.0.1", CreatedAt: now},
+		},
 	}
-	return errors.New("update failed - no user found")
-}
-
-// DeleteUser deletes one user from the database, by id
-func (m *TestDBRepo) DeleteUser(id int) error {
-	return nil
-}
-
-// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *TestDBRepo) InsertUser(user data.User) (int, error) {
-	return 2, nil
-}
-
-// ResetPassword is the method we will use to change a user's password.
-func (m *TestDBRepo) ResetPassword(id int, password string) error {
-	return nil
 }
-
-// InsertUserImage inserts a user profile image into the database.
-func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	return 1, nil
-}
\ No newline at end of file
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Errors every DatabaseRepo returns, whatever database is behind it, so that
+// callers can tell a user's mistake from an outage with errors.Is. Other
+// errors mean the database itself failed.
+var (
+	// ErrNotFound is returned when the row asked for, or a row it refers to,
+	// does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrDuplicateEmail is returned when another user already has the email address.
+	ErrDuplicateEmail = errors.New("email address is already in use")
+
+	// ErrConflict is returned when a write clashes with a row that exists,
+	// e.g. an API key or identity that is already stored.
+	ErrConflict = errors.New("conflicts with existing data")
+
+	// ErrVersionMismatch is returned when a row has been changed since the
+	// version the caller read, so its update would overwrite someone else's.
+	ErrVersionMismatch = errors.New("changed since it was read")
+)
+
+// MaxSearchLength is how many characters a query passed to SearchUsers may
+// have. Longer ones only give the database more to parse and compare.
+const MaxSearchLength = 100
+
+// ErrSearchTooLong is returned by CheckSearch for a query over MaxSearchLength.
+var ErrSearchTooLong = fmt.Errorf("search query must be at most %d characters", MaxSearchLength)
+
+// CheckSearch returns ErrSearchTooLong when query is too long to be passed to
+// SearchUsers, so that every handler that searches turns it down the same way.
+func CheckSearch(query string) error {
+	if utf8.RuneCountInString(query) > MaxSearchLength {
+		return ErrSearchTooLong
+	}
+	return nil
+}
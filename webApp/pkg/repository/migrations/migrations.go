@@ -0,0 +1,274 @@
+// Package migrations keeps the database schema up to date. Migrations are SQL
+// files embedded in the binary, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql; the versions applied to a database are recorded
+// in its schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// files holds one directory of migrations per database: postgres and sqlite.
+// Both have the same versions.
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// directories maps a database/sql driver name to its migrations
+var directories = map[string]string{
+	"pgx":    "postgres",
+	"sqlite": "sqlite",
+}
+
+// lockID is the Postgres advisory lock held while migrating, so that servers
+// starting at the same time do not apply a migration twice.
+const lockID = 7262026
+
+// Migration is one step of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied, and when.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and rolls back migrations on a database. Driver is the
+// database/sql driver name db was opened with.
+type Migrator struct {
+	DB         *sql.DB
+	Driver     string
+	Migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded in the binary, for a
+// database opened with driver "pgx" (Postgres) or "sqlite".
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	dir, ok := directories[driver]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Driver: driver, Migrations: all}, nil
+}
+
+// Load reads the migrations in the top directory of fsys, ordered by version.
+// Every version needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range names {
+		base := strings.TrimSuffix(file, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		versionPart, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionPart)
+		if !ok || err != nil || version < 1 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>.up.sql or .down.sql", file)
+		}
+
+		contents, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, name)
+		}
+
+		if direction == ".up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	var all []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		all = append(all, *m)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+
+	return all, nil
+}
+
+// Up applies every migration that has not been applied yet, and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+					migration.Version, migration.Name, time.Now())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists every migration, and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, migration := range m.Migrations {
+			status := MigrationStatus{Migration: migration}
+			if at, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock runs fn on one connection while holding the advisory lock, with the
+// versions applied so far. SQLite has no advisory locks, but the repository
+// opens it with a single connection, which serializes migrations just as well.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, applied map[int]time.Time) error) error {
+	// advisory locks belong to a session, so everything runs on one connection
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Driver == "pgx" {
+		_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID)
+	}
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version integer primary key,
+		name character varying(255) not null,
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
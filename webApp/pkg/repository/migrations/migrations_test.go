@@ -0,0 +1,94 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func Test_New(t *testing.T) {
+	postgres, err := New(nil, "pgx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(postgres.Migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	// versions start at 1 and have no gaps
+	for i, migration := range postgres.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf("expected version %d but got %d (%s)", i+1, migration.Version, migration.Name)
+		}
+	}
+
+	// every database has the same versions
+	sqlite, err := New(nil, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sqlite.Migrations) != len(postgres.Migrations) {
+		t.Fatalf("expected %d sqlite migrations but got %d", len(postgres.Migrations), len(sqlite.Migrations))
+	}
+
+	for i, migration := range sqlite.Migrations {
+		if migration.Version != postgres.Migrations[i].Version || migration.Name != postgres.Migrations[i].Name {
+			t.Errorf("sqlite migration %d_%s does not match postgres %d_%s", migration.Version, migration.Name, postgres.Migrations[i].Version, postgres.Migrations[i].Name)
+		}
+	}
+
+	if _, err := New(nil, "mysql"); err == nil {
+		t.Error("expected error for a driver without migrations")
+	}
+}
+
+func Test_Load(t *testing.T) {
+	var tests = []struct {
+		name          string
+		files         fstest.MapFS
+		expected      int
+		errorExpected bool
+	}{
+		{
+			"valid, out of order",
+			fstest.MapFS{
+				"0002_b.up.sql":   {Data: []byte("b up")},
+				"0002_b.down.sql": {Data: []byte("b down")},
+				"0001_a.up.sql":   {Data: []byte("a up")},
+				"0001_a.down.sql": {Data: []byte("a down")},
+				"README.md":       {Data: []byte("ignored")},
+			},
+			2,
+			false,
+		},
+		{"missing down", fstest.MapFS{"0001_a.up.sql": {Data: []byte("a up")}}, 0, true},
+		{"no version", fstest.MapFS{"a.up.sql": {Data: []byte("a up")}, "a.down.sql": {Data: []byte("a down")}}, 0, true},
+		{"no direction", fstest.MapFS{"0001_a.sql": {Data: []byte("a")}}, 0, true},
+		{
+			"two names for one version",
+			fstest.MapFS{"0001_a.up.sql": {Data: []byte("a up")}, "0001_b.down.sql": {Data: []byte("b down")}},
+			0,
+			true,
+		},
+	}
+
+	for _, e := range tests {
+		all, err := Load(e.files)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+
+		if len(all) != e.expected {
+			t.Errorf("%s: expected %d migrations but got %d", e.name, e.expected, len(all))
+		}
+	}
+
+	all, _ := Load(tests[0].files)
+	if all[0].Name != "a" || all[0].Up != "a up" || all[1].Down != "b down" {
+		t.Errorf("migrations loaded wrongly: %+v", all)
+	}
+}
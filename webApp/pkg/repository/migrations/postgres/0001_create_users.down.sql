@@ -0,0 +1,2 @@
+DROP TABLE public.user_images;
+DROP TABLE public.users;
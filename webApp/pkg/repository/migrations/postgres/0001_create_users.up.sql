@@ -0,0 +1,20 @@
+-- databases made before migrations, from the users.sql dump, already have the
+-- tables of 0001 to 0006, so these leave what exists alone
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    first_name character varying(255),
+    last_name character varying(255),
+    email character varying(255),
+    password character varying(255),
+    is_admin integer,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.user_images (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    file_name character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
@@ -0,0 +1,2 @@
+DROP TABLE public.user_mfa_recovery_codes;
+DROP TABLE public.user_mfa;
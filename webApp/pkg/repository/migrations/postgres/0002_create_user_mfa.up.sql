@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS public.user_mfa (
+    user_id integer PRIMARY KEY REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    secret character varying(64) NOT NULL,
+    enabled boolean DEFAULT false NOT NULL,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.user_mfa_recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    created_at timestamp without time zone
+);
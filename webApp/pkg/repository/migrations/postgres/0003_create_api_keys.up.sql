@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    name character varying(255) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash character varying(64) NOT NULL UNIQUE,
+    scopes character varying(255) NOT NULL,
+    created_at timestamp without time zone,
+    last_used_at timestamp without time zone,
+    revoked_at timestamp without time zone
+);
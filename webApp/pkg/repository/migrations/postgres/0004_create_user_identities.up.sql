@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS public.user_identities (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    provider character varying(255) NOT NULL,
+    subject character varying(255) NOT NULL,
+    email character varying(255),
+    created_at timestamp without time zone,
+    UNIQUE (provider, subject)
+);
@@ -0,0 +1,12 @@
+-- no foreign keys: the log outlives the users it mentions
+CREATE TABLE IF NOT EXISTS public.audit_events (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    actor_id integer,
+    action character varying(64) NOT NULL,
+    target_user_id integer,
+    ip character varying(255),
+    details jsonb,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON public.audit_events USING btree (created_at);
@@ -0,0 +1 @@
+DROP INDEX public.users_email_key;
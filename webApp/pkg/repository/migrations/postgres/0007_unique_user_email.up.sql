@@ -0,0 +1,2 @@
+-- one active account per address; deleted users keep theirs until purged
+CREATE UNIQUE INDEX users_email_key ON public.users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+-- bumped by every update, so that concurrent edits can be detected
+ALTER TABLE public.users ADD COLUMN version integer NOT NULL DEFAULT 1;
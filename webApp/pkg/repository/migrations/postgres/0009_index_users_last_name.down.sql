@@ -0,0 +1 @@
+DROP INDEX public.users_last_name_id_idx;
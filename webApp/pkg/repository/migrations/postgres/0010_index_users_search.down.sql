@@ -0,0 +1,3 @@
+-- pg_trgm stays, as something else may have come to use it
+DROP INDEX public.users_search_trgm_idx;
+DROP INDEX public.users_search_fts_idx;
@@ -0,0 +1,9 @@
+-- SearchUsers matches the words of a user's names and email with full-text
+-- search, and near misses with trigrams; both index the same expression
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX users_search_fts_idx ON public.users
+    USING gin (to_tsvector('simple', lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''))))
+    WHERE deleted_at IS NULL;
+CREATE INDEX users_search_trgm_idx ON public.users
+    USING gin (lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, '')) gin_trgm_ops)
+    WHERE deleted_at IS NULL;
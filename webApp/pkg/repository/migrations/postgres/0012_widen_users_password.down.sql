@@ -0,0 +1,2 @@
+-- left wide: argon2id hashes would not fit back in 60 characters
+SELECT 1;
@@ -0,0 +1,3 @@
+-- databases made from the users.sql dump have password varchar(60), room for
+-- a bcrypt hash but not an argon2id one
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(255);
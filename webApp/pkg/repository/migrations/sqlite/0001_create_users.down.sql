@@ -0,0 +1,2 @@
+DROP TABLE user_images;
+DROP TABLE users;
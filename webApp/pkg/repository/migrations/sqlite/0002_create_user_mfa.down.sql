@@ -0,0 +1,2 @@
+DROP TABLE user_mfa_recovery_codes;
+DROP TABLE user_mfa;
@@ -0,0 +1,14 @@
+CREATE TABLE user_mfa (
+    user_id integer PRIMARY KEY REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    secret varchar(64) NOT NULL,
+    enabled boolean DEFAULT false NOT NULL,
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE TABLE user_mfa_recovery_codes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash varchar(64) NOT NULL,
+    created_at timestamp
+);
@@ -0,0 +1,11 @@
+CREATE TABLE api_keys (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    name varchar(255) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash varchar(64) NOT NULL UNIQUE,
+    scopes varchar(255) NOT NULL,
+    created_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp
+);
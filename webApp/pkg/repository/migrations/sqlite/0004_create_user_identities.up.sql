@@ -0,0 +1,9 @@
+CREATE TABLE user_identities (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    provider varchar(255) NOT NULL,
+    subject varchar(255) NOT NULL,
+    email varchar(255),
+    created_at timestamp,
+    UNIQUE (provider, subject)
+);
@@ -0,0 +1,12 @@
+-- no foreign keys: the log outlives the users it mentions
+CREATE TABLE audit_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    actor_id integer,
+    action varchar(64) NOT NULL,
+    target_user_id integer,
+    ip varchar(255),
+    details text,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
@@ -0,0 +1 @@
+DROP INDEX users_last_name_id_idx;
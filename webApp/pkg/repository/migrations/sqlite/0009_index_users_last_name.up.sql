@@ -0,0 +1,2 @@
+-- AllUsers and EachUser list users in this order, EachUser a batch at a time
+CREATE INDEX users_last_name_id_idx ON users (last_name, id) WHERE deleted_at IS NULL;
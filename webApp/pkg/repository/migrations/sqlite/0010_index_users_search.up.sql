@@ -0,0 +1,2 @@
+-- SQLite has no index for SearchUsers to use: it scans the users with like
+SELECT 1;
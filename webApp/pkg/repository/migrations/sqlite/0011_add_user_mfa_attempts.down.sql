@@ -0,0 +1,3 @@
+ALTER TABLE user_mfa DROP COLUMN locked_until;
+ALTER TABLE user_mfa DROP COLUMN failed_attempts;
+ALTER TABLE user_mfa DROP COLUMN last_used_step;
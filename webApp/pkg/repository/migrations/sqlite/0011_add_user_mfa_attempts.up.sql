@@ -0,0 +1,5 @@
+-- a TOTP code is accepted once: codes of last_used_step or earlier are refused;
+-- failed_attempts and locked_until limit the codes that can be guessed
+ALTER TABLE user_mfa ADD COLUMN last_used_step integer NOT NULL DEFAULT 0;
+ALTER TABLE user_mfa ADD COLUMN failed_attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE user_mfa ADD COLUMN locked_until timestamp;
@@ -0,0 +1,2 @@
+-- SQLite does not enforce the length of varchar columns
+SELECT 1;
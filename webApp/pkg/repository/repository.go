@@ -1,18 +1,66 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 	"webApp/pkg/data"
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	InsertUser(user data.User) (int, error)
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
-}
\ No newline at end of file
+	// WithTx runs fn as one unit of work: what fn does through repo is kept
+	// when fn returns nil, and undone when it returns an error.
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+	AllUsers(ctx context.Context) ([]*data.User, error)
+	// EachUser calls fn for every user AllUsers returns, in the same order,
+	// without holding them all in memory. It stops at the first error fn
+	// returns, and returns it.
+	EachUser(ctx context.Context, fn func(u *data.User) error) error
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	// SearchUsers returns up to limit users whose names or email match query,
+	// best matches first. Every word of query must begin a word of the names,
+	// or the email, so that a prefix is enough; Postgres also finds near
+	// misses, such as typos.
+	SearchUsers(ctx context.Context, query string, limit int) ([]*data.User, error)
+	UpdateUser(ctx context.Context, u data.User) error
+	DeleteUser(ctx context.Context, id int) error
+	RestoreUser(ctx context.Context, id int) error
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]int, []string, error)
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	// InsertHashedUser is InsertUser for a user whose Password is already
+	// hashed, so that the slow hashing can be done before a transaction is
+	// begun rather than inside it.
+	InsertHashedUser(ctx context.Context, user data.User) (int, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error)
+	SaveUserMFA(ctx context.Context, m data.UserMFA) error
+	DeleteUserMFA(ctx context.Context, userID int) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	// UseTOTPStep records that a TOTP code of step was accepted. It returns
+	// false if a code of that step, or a later one, already was, so that a
+	// code cannot be replayed. Like a used recovery code, it clears the
+	// failed attempts.
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	// BeginMFAAttempt counts an attempt at a second factor, before it is
+	// checked, and returns false if the user is locked out. The attempt that
+	// reaches limit locks the user out until lockedUntil; from then on, until
+	// a code is accepted, every attempt does, so one is allowed per lockout.
+	BeginMFAAttempt(ctx context.Context, userID int, limit int, now, lockedUntil time.Time) (bool, error)
+	AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*data.APIKey, error)
+	InsertAPIKey(ctx context.Context, k data.APIKey) (int, error)
+	RevokeAPIKey(ctx context.Context, userID, id int) error
+	TouchAPIKey(ctx context.Context, id int) error
+	GetUserIdentity(ctx context.Context, provider, subject string) (*data.UserIdentity, error)
+	InsertUserIdentity(ctx context.Context, i data.UserIdentity) (int, error)
+	AuditRepo
+}
+
+// AuditRepo writes and queries the audit log.
+type AuditRepo interface {
+	InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error)
+	AllAuditEvents(ctx context.Context, f data.AuditFilter) ([]*data.AuditEvent, error)
+}
@@ -0,0 +1,824 @@
+// Package repotest checks that a repository.DatabaseRepo behaves like every
+// other one, so handlers get the same results whichever database they run on,
+// and so tests on the in-memory repository stay honest.
+//
+// Each implementation calls Run from its own tests, with a factory that
+// returns a new, empty repository:
+//
+//	func TestMemoryDBRepo_Conformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+//			return dbrepo.NewMemoryDBRepo(nil)
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+	"webApp/pkg/data"
+	"webApp/pkg/repository"
+)
+
+// Factory returns a new repository with no data in it. Anything it opens
+// should be closed with t.Cleanup.
+type Factory func(t *testing.T) repository.DatabaseRepo
+
+// Run runs the whole suite, one subtest per behaviour, each on a repository
+// of its own.
+func Run(t *testing.T, newRepo Factory) {
+	var tests = []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"not found", testNotFound},
+		{"duplicate email", testDuplicateEmail},
+		{"ordering", testOrdering},
+		{"each user", testEachUser},
+		{"search", testSearch},
+		{"image replacement", testImageReplacement},
+		{"soft delete", testSoftDelete},
+		{"purge", testPurge},
+		{"api keys", testAPIKeys},
+		{"mfa", testMFA},
+		{"audit events", testAuditEvents},
+		{"cancelled context", testCancelledContext},
+		{"transactions", testTransactions},
+		{"versions", testVersions},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			e.test(t, newRepo(t))
+		})
+	}
+}
+
+// insertUser inserts a user with password "secret", failing the test if it cannot
+func insertUser(t *testing.T, repo repository.DatabaseRepo, firstName, lastName, email string) int {
+	t.Helper()
+	ctx := context.Background()
+
+	id, err := repo.InsertUser(ctx, data.User{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     email,
+		Password:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("insert %s: %s", email, err)
+	}
+
+	return id
+}
+
+func testNotFound(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	if _, err := repo.GetUser(ctx, id + 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUser: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserByEmail: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.DeleteUser(ctx, id + 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteUser: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.RestoreUser(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("RestoreUser of a user that is not deleted: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetUserMFA(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserMFA: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetAPIKeyByHash(ctx, data.HashAPIKey("wak_nope")); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.RevokeAPIKey(ctx, id, 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("RevokeAPIKey: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetUserIdentity(ctx, "https://accounts.example.com", "nobody"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserIdentity: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.UpdateUser(ctx, data.User{ID: id + 100, FirstName: "No", LastName: "One", Email: "nobody@example.com"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateUser: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.ResetPassword(ctx, id+100, "secret"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ResetPassword: expected ErrNotFound, but got %v", err)
+	}
+
+	// rows that refer to a user that does not exist
+	if _, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id + 100, FileName: "nobody.png"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("InsertUserImage: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: id + 100, Name: "nobody", Hash: data.HashAPIKey("wak_nobody")}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("InsertAPIKey: expected ErrNotFound, but got %v", err)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	first := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	second := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+
+	if _, err := repo.InsertUser(ctx, data.User{FirstName: "Other", LastName: "Admin", Email: "admin@example.com", Password: "secret"}); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("InsertUser: expected ErrDuplicateEmail, but got %v", err)
+	}
+
+	if _, err := repo.InsertHashedUser(ctx, data.User{FirstName: "Other", LastName: "Admin", Email: "admin@example.com", Password: "$2a$04$hash"}); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("InsertHashedUser: expected ErrDuplicateEmail, but got %v", err)
+	}
+
+	hashedID, err := repo.InsertHashedUser(ctx, data.User{FirstName: "Hashed", LastName: "User", Email: "hashed@example.com", Password: "$2a$04$hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashed, _ := repo.GetUserByEmail(ctx, "hashed@example.com"); hashed == nil || hashed.ID != hashedID || hashed.Password != "$2a$04$hash" {
+		t.Errorf("InsertHashedUser: expected the password stored as it is, but got %v", hashed)
+	}
+
+	err = repo.UpdateUser(ctx, data.User{ID: second, FirstName: "Jack", LastName: "Smith", Email: "admin@example.com"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("UpdateUser: expected ErrDuplicateEmail, but got %v", err)
+	}
+
+	user, _ := repo.GetUser(ctx, second)
+	if user == nil || user.Email != "jack@example.com" {
+		t.Errorf("UpdateUser: a failed update changed the user to %v", user)
+	}
+
+	// keeping your own address is fine
+	err = repo.UpdateUser(ctx, data.User{ID: first, FirstName: "Administrator", LastName: "User", Email: "admin@example.com"})
+	if err != nil {
+		t.Errorf("UpdateUser: unexpected error keeping the same address: %s", err)
+	}
+
+	// the address of a deleted user may be taken, which then blocks restoring it
+	if err := repo.DeleteUser(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	third := insertUser(t, repo, "New", "Admin", "admin@example.com")
+
+	if err := repo.RestoreUser(ctx, first); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("RestoreUser: expected ErrDuplicateEmail when the address has been taken, but got %v", err)
+	}
+
+	user, _ = repo.GetUserByEmail(ctx, "admin@example.com")
+	if user == nil || user.ID != third {
+		t.Errorf("GetUserByEmail: expected user %d, but got %v", third, user)
+	}
+}
+
+func testOrdering(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	users, err := repo.AllUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 0 {
+		t.Fatalf("expected a new repository to be empty, but it has %d users", len(users))
+	}
+
+	smithA := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+	adams := insertUser(t, repo, "Ann", "Adams", "ann@example.com")
+	smithB := insertUser(t, repo, "Jill", "Smith", "jill@example.com")
+	gone := insertUser(t, repo, "Zed", "Brown", "zed@example.com")
+
+	if err := repo.DeleteUser(ctx, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err = repo.AllUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// by last name, then by id
+	expected := []int{adams, smithA, smithB}
+	if len(users) != len(expected) {
+		t.Fatalf("expected %d users, but got %d", len(expected), len(users))
+	}
+
+	for i, id := range expected {
+		if users[i].ID != id {
+			t.Errorf("position %d: expected user %d, but got %d", i, id, users[i].ID)
+		}
+	}
+
+	if !(adams > smithA && smithB > adams && gone > smithB) {
+		t.Errorf("expected ids to increase, but got %d %d %d %d", smithA, adams, smithB, gone)
+	}
+
+	user, _ := repo.GetUser(ctx, smithA)
+	if user == nil || user.Password == "secret" {
+		t.Error("expected the password to be stored hashed")
+	} else if ok, _ := user.PasswordMatches("secret"); !ok {
+		t.Error("stored password hash does not match")
+	}
+}
+
+func testSearch(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	jack := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+	jill := insertUser(t, repo, "Jill", "Smith", "jill@example.com")
+	jackson := insertUser(t, repo, "Jackson", "Brown", "jbrown@example.com")
+	ann := insertUser(t, repo, "Ann", "Adams", "ann@example.com")
+	gone := insertUser(t, repo, "Zed", "Deleted", "zed@example.com")
+
+	if err := repo.DeleteUser(ctx, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		query   string
+		ordered bool
+		expect  []int
+	}{
+		{"whole word first", "jack", true, []int{jack, jackson}},
+		// which of equally good matches comes first is up to the repository
+		{"prefixes of two words", "smith j", false, []int{jack, jill}},
+		{"any case", "ADAMS", true, []int{ann}},
+		{"email", "ann@example.com", true, []int{ann}},
+		{"no match", "zzz", true, nil},
+		{"deleted", "zed", true, nil},
+		{"blank", "  ", true, nil},
+		{"wildcards are plain characters", "%", true, nil},
+	}
+
+	for _, e := range tests {
+		users, err := repo.SearchUsers(ctx, e.query, 10)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		var ids []int
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if !e.ordered {
+			sort.Ints(ids)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(e.expect) {
+			t.Errorf("%s: expected users %v, but got %v", e.name, e.expect, ids)
+		}
+	}
+
+	users, err := repo.SearchUsers(ctx, "smith", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || (users[0].ID != jack && users[0].ID != jill) {
+		t.Errorf("expected one of the Smiths, but got %v", users)
+	}
+}
+
+func testEachUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	smithA := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+	adams := insertUser(t, repo, "Ann", "Adams", "ann@example.com")
+	smithB := insertUser(t, repo, "Jill", "Smith", "jill@example.com")
+	gone := insertUser(t, repo, "Zed", "Brown", "zed@example.com")
+
+	if err := repo.DeleteUser(ctx, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	// the order of AllUsers
+	var ids []int
+	err := repo.EachUser(ctx, func(u *data.User) error {
+		ids = append(ids, u.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{adams, smithA, smithB}
+	if len(ids) != len(expected) {
+		t.Fatalf("expected users %v, but got %v", expected, ids)
+	}
+	for i, id := range expected {
+		if ids[i] != id {
+			t.Errorf("position %d: expected user %d, but got %d", i, id, ids[i])
+		}
+	}
+
+	// an error from fn stops the iteration, and is returned
+	stop := errors.New("stop")
+	calls := 0
+	err = repo.EachUser(ctx, func(u *data.User) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected one call and the error fn returned, but got %d calls and %v", calls, err)
+	}
+}
+
+func testImageReplacement(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	other := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+
+	user, _ := repo.GetUser(ctx, id)
+	if user == nil || user.ProfilePic.FileName != "" {
+		t.Fatalf("expected no profile picture, but got %v", user)
+	}
+
+	for _, fileName := range []string{"first.png", "second.png"} {
+		if _, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: fileName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.InsertUserImage(ctx, data.UserImage{UserID: other, FileName: "other.png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if user == nil || user.ProfilePic.FileName != "second.png" {
+		t.Errorf("GetUser: expected second.png to replace first.png, but got %v", user)
+	}
+
+	user, _ = repo.GetUserByEmail(ctx, "admin@example.com")
+	if user == nil || user.ProfilePic.FileName != "second.png" {
+		t.Errorf("GetUserByEmail: expected second.png, but got %v", user)
+	}
+
+	user, _ = repo.GetUser(ctx, other)
+	if user == nil || user.ProfilePic.FileName != "other.png" {
+		t.Errorf("expected another user's picture to be left alone, but got %v", user)
+	}
+}
+
+func testSoftDelete(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	keyID, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: id, Name: "read", Hash: data.HashAPIKey("wak_soft-delete"), Scopes: []string{data.ScopeUsersRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteUser(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteUser(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleting twice: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetUser(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUser of a deleted user: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetUserByEmail(ctx, "admin@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserByEmail of a deleted user: expected ErrNotFound, but got %v", err)
+	}
+
+	if _, err := repo.GetAPIKeyByHash(ctx, data.HashAPIKey("wak_soft-delete")); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the key of a deleted user to stop working, but got %v", err)
+	}
+
+	if err := repo.RestoreUser(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetUser(ctx, id)
+	if err != nil || user.Email != "admin@example.com" {
+		t.Errorf("expected restored user, but got %v %v", user, err)
+	}
+
+	key, err := repo.GetAPIKeyByHash(ctx, data.HashAPIKey("wak_soft-delete"))
+	if err != nil || key.ID != keyID {
+		t.Errorf("expected key %d to work again, but got %v %v", keyID, key, err)
+	}
+}
+
+func testPurge(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	kept := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	purged := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+	recent := insertUser(t, repo, "Jill", "Smith", "jill@example.com")
+
+	images := map[int]string{kept: "shared.png", purged: "shared.png", recent: "recent.png"}
+	for id, fileName := range images {
+		if _, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: fileName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.InsertUserImage(ctx, data.UserImage{UserID: purged, FileName: "purged.png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteUser(ctx, purged); err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := time.Now().Add(time.Second)
+
+	ids, files, err := repo.PurgeDeletedUsers(ctx, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 1 || ids[0] != purged {
+		t.Errorf("expected to purge user %d, but purged %v", purged, ids)
+	}
+
+	if len(files) != 1 || files[0] != "purged.png" {
+		t.Errorf("expected to remove purged.png only, but got %v", files)
+	}
+
+	// the purged user is gone for good, the others are untouched
+	if err := repo.RestoreUser(ctx, purged); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("RestoreUser of a purged user: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.DeleteUser(ctx, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, _, err = repo.PurgeDeletedUsers(ctx, cutoff.Add(-time.Hour))
+	if err != nil || len(ids) != 0 {
+		t.Errorf("expected a recently deleted user to be kept, but purged %v %v", ids, err)
+	}
+
+	if _, err := repo.GetUser(ctx, kept); err != nil {
+		t.Errorf("GetUser of a user that was not deleted: %s", err)
+	}
+}
+
+func testAPIKeys(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	other := insertUser(t, repo, "Jack", "Smith", "jack@example.com")
+
+	readID, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: id, Name: "read", Hash: data.HashAPIKey("wak_read"), Scopes: []string{data.ScopeUsersRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeID, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: id, Name: "write", Hash: data.HashAPIKey("wak_write"), Scopes: []string{data.ScopeUsersRead, data.ScopeUsersWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: other, Name: "read", Hash: data.HashAPIKey("wak_read"), Scopes: []string{data.ScopeUsersRead}}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("InsertAPIKey: expected ErrConflict for a key that already exists, but got %v", err)
+	}
+
+	if err := repo.RevokeAPIKey(ctx, other, readID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("RevokeAPIKey of another user's key: expected ErrNotFound, but got %v", err)
+	}
+
+	if err := repo.RevokeAPIKey(ctx, id, readID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.TouchAPIKey(ctx, writeID); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := repo.AllAPIKeys(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != readID || keys[1].ID != writeID {
+		t.Fatalf("expected keys %d and %d in order, but got %v", readID, writeID, keys)
+	}
+
+	if !keys[0].Revoked() || keys[1].Revoked() {
+		t.Error("expected only the read key to be revoked")
+	}
+
+	if keys[1].LastUsedAt == nil {
+		t.Error("expected the write key to have been used")
+	}
+
+	if len(keys[1].Scopes) != 2 || keys[1].Scopes[1] != data.ScopeUsersWrite {
+		t.Errorf("expected scopes to round trip, but got %v", keys[1].Scopes)
+	}
+
+	keys, _ = repo.AllAPIKeys(ctx, other)
+	if len(keys) != 0 {
+		t.Errorf("expected no keys for another user, but got %d", len(keys))
+	}
+}
+
+func testMFA(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	err := repo.SaveUserMFA(ctx, data.UserMFA{UserID: id, Secret: "SECRET", RecoveryCodes: []string{"hash-a", "hash-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saving again updates, rather than adding a second row
+	err = repo.SaveUserMFA(ctx, data.UserMFA{UserID: id, Secret: "SECRET", Enabled: true, RecoveryCodes: []string{"hash-a", "hash-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, err := repo.UseRecoveryCode(ctx, id, "hash-a")
+	if err != nil || !used {
+		t.Errorf("expected recovery code to be used, but got %t %v", used, err)
+	}
+
+	used, _ = repo.UseRecoveryCode(ctx, id, "hash-a")
+	if used {
+		t.Error("expected a recovery code to work only once")
+	}
+
+	userMFA, err := repo.GetUserMFA(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !userMFA.Enabled || len(userMFA.RecoveryCodes) != 1 || userMFA.RecoveryCodes[0] != "hash-b" {
+		t.Errorf("expected enabled, with recovery code hash-b left, but got %v", userMFA)
+	}
+
+	// a TOTP step is accepted once, and never again after a later one
+	for _, e := range []struct {
+		step     int64
+		expected bool
+	}{{100, true}, {100, false}, {102, true}, {101, false}} {
+		if used, err := repo.UseTOTPStep(ctx, id, e.step); err != nil || used != e.expected {
+			t.Errorf("UseTOTPStep(%d): expected %t, but got %t %v", e.step, e.expected, used, err)
+		}
+	}
+
+	// the attempt that reaches the limit locks the user out
+	now := time.Now().Truncate(time.Second)
+	for i := 1; i <= 4; i++ {
+		allowed, err := repo.BeginMFAAttempt(ctx, id, 3, now, now.Add(time.Hour))
+		if err != nil || allowed != (i <= 3) {
+			t.Errorf("attempt %d: expected allowed %t, but got %t %v", i, i <= 3, allowed, err)
+		}
+	}
+
+	userMFA, _ = repo.GetUserMFA(ctx, id)
+	if userMFA.FailedAttempts != 3 || userMFA.LockedUntil == nil || !userMFA.LockedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected 3 attempts, locked for an hour, but got %d until %v", userMFA.FailedAttempts, userMFA.LockedUntil)
+	}
+
+	// once the lockout is over, every attempt locks again until one succeeds
+	later := now.Add(2 * time.Hour)
+	if allowed, _ := repo.BeginMFAAttempt(ctx, id, 3, later, later.Add(time.Hour)); !allowed {
+		t.Error("expected an attempt after the lockout")
+	}
+	if allowed, _ := repo.BeginMFAAttempt(ctx, id, 3, later, later.Add(time.Hour)); allowed {
+		t.Error("expected a second attempt after the lockout to be refused")
+	}
+
+	if used, _ := repo.UseRecoveryCode(ctx, id, "hash-b"); !used {
+		t.Error("expected recovery code hash-b to be used")
+	}
+
+	userMFA, _ = repo.GetUserMFA(ctx, id)
+	if userMFA.FailedAttempts != 0 || userMFA.LockedUntil != nil || userMFA.LastUsedStep != 102 {
+		t.Errorf("expected the attempts cleared and step 102 kept, but got %d, %v and %d", userMFA.FailedAttempts, userMFA.LockedUntil, userMFA.LastUsedStep)
+	}
+
+	// saving the settings again keeps the state of the codes
+	if err := repo.SaveUserMFA(ctx, *userMFA); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := repo.UseTOTPStep(ctx, id, 102); used {
+		t.Error("expected step 102 to stay used after SaveUserMFA")
+	}
+
+	if err := repo.DeleteUserMFA(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetUserMFA(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserMFA after delete: expected ErrNotFound, but got %v", err)
+	}
+}
+
+func testAuditEvents(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	events := []data.AuditEvent{
+		{ActorID: 1, Action: data.AuditLogin, TargetUserID: 1, IP: "127.0.0.1"},
+		{ActorID: 1, Action: data.AuditUserDeleted, TargetUserID: 2, IP: "127.0.0.1", Details: map[string]any{"reason": "test"}},
+		{Action: data.AuditLoginFailed, IP: "127.0.0.1"},
+	}
+
+	var ids []int
+	for _, e := range events {
+		id, err := repo.InsertAuditEvent(ctx, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	all, err := repo.AllAuditEvents(ctx, data.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newest first
+	if len(all) != 3 || all[0].ID != ids[2] || all[2].ID != ids[0] {
+		t.Fatalf("expected events %v newest first, but got %v", ids, all)
+	}
+
+	if all[1].Details["reason"] != "test" {
+		t.Errorf("expected details to round trip, but got %v", all[1].Details)
+	}
+
+	filtered, _ := repo.AllAuditEvents(ctx, data.AuditFilter{ActorID: 1, Limit: 1})
+	if len(filtered) != 1 || filtered[0].ID != ids[1] {
+		t.Errorf("expected the newest event of actor 1, but got %v", filtered)
+	}
+
+	filtered, _ = repo.AllAuditEvents(ctx, data.AuditFilter{Action: data.AuditLogin, TargetUserID: 1})
+	if len(filtered) != 1 || filtered[0].ID != ids[0] {
+		t.Errorf("expected the login event, but got %v", filtered)
+	}
+
+	filtered, _ = repo.AllAuditEvents(ctx, data.AuditFilter{Since: time.Now().Add(time.Hour)})
+	if len(filtered) != 0 {
+		t.Errorf("expected no events in the future, but got %d", len(filtered))
+	}
+}
+
+func testCancelledContext(t *testing.T, repo repository.DatabaseRepo) {
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.GetUser(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetUser: expected context.Canceled, but got %v", err)
+	}
+
+	if _, err := repo.AllUsers(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("AllUsers: expected context.Canceled, but got %v", err)
+	}
+
+	if err := repo.DeleteUser(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteUser: expected context.Canceled, but got %v", err)
+	}
+
+	// nothing happened
+	if _, err := repo.GetUser(context.Background(), id); err != nil {
+		t.Errorf("user should still be there, but got %v", err)
+	}
+}
+
+func testTransactions(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	errRollback := errors.New("roll back")
+
+	// a user with a picture is created as a whole
+	var id int
+	err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		id, err = tx.InsertUser(ctx, data.User{FirstName: "Admin", LastName: "User", Email: "admin@example.com", Password: "secret"})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "admin.png"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetUser(ctx, id)
+	if err != nil || user.ProfilePic.FileName != "admin.png" {
+		t.Fatalf("expected the committed user with admin.png, but got %v, %v", user, err)
+	}
+
+	// or not at all
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		newID, err := tx.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", Password: "secret"})
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.InsertUserImage(ctx, data.UserImage{UserID: newID, FileName: "jack.png"}); err != nil {
+			return err
+		}
+
+		if _, err := tx.GetUser(ctx, newID); err != nil {
+			t.Errorf("expected the transaction to see its own changes, but got %v", err)
+		}
+
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Errorf("WithTx: expected the error fn returned, but got %v", err)
+	}
+
+	if _, err := repo.GetUserByEmail(ctx, "jack@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the rolled back user to be gone, but got %v", err)
+	}
+
+	// a statement that fails undoes the ones before it
+	keyID, err := repo.InsertAPIKey(ctx, data.APIKey{UserID: id, Name: "read", Hash: data.HashAPIKey("wak_tx"), Scopes: []string{data.ScopeUsersRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		if err := tx.ResetPassword(ctx, id, "new-secret"); err != nil {
+			return err
+		}
+		if err := tx.RevokeAPIKey(ctx, id, keyID); err != nil {
+			return err
+		}
+
+		// joins the transaction it is called in
+		return tx.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+			return tx.RevokeAPIKey(ctx, id, keyID+100)
+		})
+	})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("WithTx: expected ErrNotFound, but got %v", err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if ok, _ := user.PasswordMatches("secret"); !ok {
+		t.Error("expected the password reset to be rolled back")
+	}
+
+	keys, _ := repo.AllAPIKeys(ctx, id)
+	if len(keys) != 1 || keys[0].Revoked() {
+		t.Errorf("expected the revocation to be rolled back, but got %v", keys)
+	}
+}
+
+func testVersions(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	user, _ := repo.GetUser(ctx, id)
+	if user == nil || user.Version != 1 {
+		t.Fatalf("expected a new user at version 1, but got %v", user)
+	}
+
+	user.FirstName = "Administrator"
+	if err := repo.UpdateUser(ctx, *user); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same version again is an update made without seeing the first one
+	user.FirstName = "Someone Else"
+	if err := repo.UpdateUser(ctx, *user); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("UpdateUser of an old version: expected ErrVersionMismatch, but got %v", err)
+	}
+
+	stored, _ := repo.GetUserByEmail(ctx, "admin@example.com")
+	if stored == nil || stored.Version != 2 || stored.FirstName != "Administrator" {
+		t.Fatalf("expected Administrator at version 2, but got %v", stored)
+	}
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 1 || users[0].Version != 2 {
+		t.Errorf("AllUsers: expected version 2, but got %v", users)
+	}
+
+	// version 0 updates whatever is stored
+	user.Version = 0
+	if err := repo.UpdateUser(ctx, *user); err != nil {
+		t.Errorf("UpdateUser without a version: %s", err)
+	}
+
+	stored, _ = repo.GetUser(ctx, id)
+	if stored == nil || stored.Version != 3 {
+		t.Errorf("expected version 3, but got %v", stored)
+	}
+
+	// a missing user is not found, whatever the version
+	if err := repo.UpdateUser(ctx, data.User{ID: id + 100, Email: "nobody@example.com", Version: 1}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateUser of a missing user: expected ErrNotFound, but got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package validator checks the payloads of the JSON API field by field, so
+// that a client learns everything that is wrong with a request at once. Its
+// Errors are also what cmd/web's Form collects for HTML forms.
+package validator
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors holds the messages for every invalid field, keyed by the name of the
+// field in the payload.
+type Errors map[string][]string
+
+// Get returns the first message for field, or "" when it is valid.
+func (e Errors) Get(field string) string {
+	if len(e[field]) == 0 {
+		return ""
+	}
+	return e[field][0]
+}
+
+// Add adds a message for field.
+func (e Errors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+// Error names the invalid fields, so that Errors can be returned as an error.
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// Validator collects the errors found in one payload.
+type Validator struct {
+	Errors Errors
+}
+
+// New returns a Validator without errors.
+func New() *Validator {
+	return &Validator{Errors: Errors{}}
+}
+
+// Check is a generic validation check: when ok is false, message is added for
+// field.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Errors.Add(field, message)
+	}
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "must not be blank")
+}
+
+// MaxLength checks that value is at most n characters long.
+func (v *Validator) MaxLength(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must be at most %d characters", n))
+}
+
+// Email checks that value is a plain email address, like jack@example.com. A
+// blank value is left to Required.
+func (v *Validator) Email(field, value string) {
+	if value == "" {
+		return
+	}
+
+	addr, err := mail.ParseAddress(value)
+	v.Check(err == nil && addr.Address == value, field, "must be an email address")
+}
+
+// Valid reports whether no errors were found.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
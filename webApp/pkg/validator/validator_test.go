@@ -0,0 +1,69 @@
+package validator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	var tests = []struct {
+		name     string
+		check    func(v *Validator)
+		expected string
+	}{
+		{"required", func(v *Validator) { v.Required("first_name", "Jack") }, ""},
+		{"required blank", func(v *Validator) { v.Required("first_name", " ") }, "must not be blank"},
+		{"max length", func(v *Validator) { v.MaxLength("password", "héllo", 5) }, ""},
+		{"max length too long", func(v *Validator) { v.MaxLength("password", "secrets", 5) }, "must be at most 5 characters"},
+		{"email", func(v *Validator) { v.Email("email", "jack@example.com") }, ""},
+		{"email blank", func(v *Validator) { v.Email("email", "") }, ""},
+		{"email invalid", func(v *Validator) { v.Email("email", "jack") }, "must be an email address"},
+		{"email with name", func(v *Validator) { v.Email("email", "Jack <jack@example.com>") }, "must be an email address"},
+		{"check", func(v *Validator) { v.Check(false, "is_admin", "must be 0 or 1") }, "must be 0 or 1"},
+	}
+
+	for _, e := range tests {
+		v := New()
+		e.check(v)
+
+		var got string
+		for _, messages := range v.Errors {
+			got = messages[0]
+		}
+
+		if got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+
+		if v.Valid() != (e.expected == "") {
+			t.Errorf("%s: Valid returned %t", e.name, v.Valid())
+		}
+	}
+}
+
+func TestValidator_Errors(t *testing.T) {
+	v := New()
+	v.Required("last_name", "")
+	v.Required("first_name", "")
+	v.Email("email", "jack")
+	v.Check(false, "email", "is already in use")
+
+	// Errors can be returned as an error, and found again
+	var err error = v.Errors
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, but got %T", err)
+	}
+
+	if len(errs["email"]) != 2 || errs.Get("email") != "must be an email address" {
+		t.Errorf("expected two messages for email, the first one first, but got %v", errs["email"])
+	}
+
+	if errs.Get("password") != "" {
+		t.Errorf("expected no message for a valid field, but got %q", errs.Get("password"))
+	}
+
+	if err.Error() != "invalid email, first_name, last_name" {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+}